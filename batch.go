@@ -0,0 +1,146 @@
+package tilemap
+
+import (
+	"sort"
+)
+
+const (
+	defaultBatchBuffer = 4 * 1024 * 1024 //flush batches in chunks of this size when not otherwise buffered
+)
+
+// Tile is a single tile for AddBatch
+type Tile struct {
+	X, Y int
+	Data []byte
+}
+
+// AddBatch adds a set of tiles under a single lock, coalescing new blobs into sequential writes.
+// Every tile is validated before anything is written.
+func (w *Tilemap) AddBatch(tiles []Tile) (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.ro {
+		err = ErrReadOnly
+		return
+	}
+	for _, t := range tiles {
+		if err = w.checkTile(t.X, t.Y, t.Data); err != nil {
+			return
+		}
+	}
+	w.batching = true
+	for _, t := range tiles {
+		if err = w.add(t.X, t.Y, t.Data); err != nil {
+			break
+		}
+	}
+	w.batching = false
+	//publish whatever made it in, even on failure
+	if w.wbufSize == 0 {
+		if lerr := w.flush(); err == nil {
+			err = lerr
+		}
+	}
+	if err == nil {
+		err = w.wrote(len(tiles))
+	}
+	return
+}
+
+// Flush writes out buffered blobs and publishes their tiles without syncing to disk
+func (w *Tilemap) Flush() (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.ro {
+		return
+	}
+	err = w.flush()
+	return
+}
+
+func (w *Tilemap) buffering() bool {
+	return w.wbufSize > 0 || w.batching
+}
+
+func (w *Tilemap) bufferLimit() int {
+	if w.wbufSize > 0 {
+		return w.wbufSize
+	}
+	return defaultBatchBuffer
+}
+
+// buffered returns true if off lands in blobs that have not been written out yet
+func (w *Tilemap) buffered(off int64) bool {
+	return len(w.wbuf) > 0 && off >= w.wbase
+}
+
+// flush writes the buffered blobs and then publishes the pending datapointers in tile order
+func (w *Tilemap) flush() (err error) {
+	if err = w.flushData(); err != nil {
+		return
+	} else if len(w.pending) == 0 {
+		return
+	}
+	tids := make([]uint64, 0, len(w.pending))
+	for tid := range w.pending {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	pending := w.pending
+	w.pending = nil
+	for i, tid := range tids {
+		if err = w.setDataPointer(tid, pending[tid]); err != nil {
+			//keep whatever was not published so reads stay consistent
+			w.pending = make(map[uint64]datapointer, len(tids)-i)
+			for _, tid := range tids[i:] {
+				w.pending[tid] = pending[tid]
+			}
+			return
+		}
+	}
+	return
+}
+
+// flushData writes the buffered blobs in a single write
+func (w *Tilemap) flushData() (err error) {
+	if len(w.wbuf) == 0 {
+		return
+	}
+	var n int
+	if n, err = w.st.WriteAt(w.wbuf, w.wbase); err != nil {
+		err = errorLine(err)
+		return
+	} else if n != len(w.wbuf) {
+		err = errorLine(ErrPartialWrite)
+		return
+	}
+	w.wbuf = w.wbuf[:0]
+	if cap(w.wbuf) > 2*w.bufferLimit() {
+		w.wbuf = nil //do not hang on to an oversized buffer
+	}
+	if w.mapped && w.foff > int64(len(w.dmm)) {
+		err = w.mapData()
+	}
+	return
+}
+
+// walkPending walks the stored index with pending datapointers overriding stored ones
+func (w *Tilemap) walkPending(fn func(tid uint64, dp datapointer) error) (err error) {
+	err = w.walkStored(func(tid uint64, dp datapointer) error {
+		if _, ok := w.pending[tid]; ok {
+			return nil
+		}
+		return fn(tid, dp)
+	})
+	if err != nil {
+		return
+	}
+	for tid, dp := range w.pending {
+		if dp.size == 0 {
+			continue
+		} else if err = fn(tid, dp); err != nil {
+			return
+		}
+	}
+	return
+}
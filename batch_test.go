@@ -0,0 +1,161 @@
+package tilemap
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestTilemapAddBatch(t *testing.T) {
+	zl := 4
+	pth := filepath.Join(tdir, `batch`)
+	wtr, err := NewTilemap(pth, zl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := []byte(`existing`)
+	if err = wtr.Add(0, 0, existing); err != nil {
+		t.Fatal(err)
+	}
+	sz := wtr.size()
+
+	//a bad tile anywhere in the batch rejects all of it
+	bad := []Tile{{X: 1, Y: 1, Data: []byte(`fine`)}, {X: 100, Y: 1, Data: []byte(`bad`)}}
+	if err = wtr.AddBatch(bad); err == nil {
+		t.Fatal("Failed to catch bad tile in batch")
+	} else if wtr.Has(1, 1) || wtr.size() != sz {
+		t.Fatal("rejected batch was partially written")
+	}
+
+	var tiles []Tile
+	expected := make(map[uint64][]byte)
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			buff := []byte(fmt.Sprintf("%d %d", x, y%4))
+			if x == 3 {
+				buff = existing
+			}
+			tiles = append(tiles, Tile{X: x, Y: y, Data: buff})
+			expected[tileid(zl, x, y)] = buff
+		}
+	}
+	if err = wtr.AddBatch(tiles); err != nil {
+		t.Fatal(err)
+	}
+	//16 rows of 4 distinct tiles, one of which is all duplicates of the existing tile
+	if st, err := wtr.Stats(); err != nil {
+		t.Fatal(err)
+	} else if st.Tiles != 256 || st.UniqueBlobs != 61 {
+		t.Fatalf("bad dedup across batch %+v", st)
+	}
+
+	//batches are published when AddBatch returns
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTiles(t, rdr, expected)
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTilemapBuffered(t *testing.T) {
+	for _, idx := range []IndexType{IndexDense, IndexSparse} {
+		t.Run(idx.String(), func(t *testing.T) {
+			testBuffered(t, idx)
+		})
+	}
+}
+
+func testBuffered(t *testing.T, idx IndexType) {
+	zl := 7
+	pth := filepath.Join(tdir, `buffered`+idx.String())
+	c := Config{Zoom: zl, Index: idx, Checksums: true, MapData: true, WriteBuffer: 64 * 1024}
+	wtr, err := NewTilemapConfig(pth, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the first tile is small enough to sit in the buffer
+	if err = wtr.Add(0, 0, []byte(`buffered`)); err != nil {
+		t.Fatal(err)
+	} else if len(wtr.wbuf) == 0 || len(wtr.pending) != 1 {
+		t.Fatal("tile was not buffered")
+	}
+	if buff, err := wtr.GetTile(0, 0); err != nil || string(buff) != `buffered` {
+		t.Fatalf("buffered tile not readable %q %v", buff, err)
+	} else if view, err := wtr.GetTileView(0, 0); err != nil || string(view) != `buffered` {
+		t.Fatalf("buffered tile not viewable %q %v", view, err)
+	}
+	if err = rdr.Refresh(); err != nil {
+		t.Fatal(err)
+	} else if rdr.Has(0, 0) {
+		t.Fatal("buffered tile visible to reader before flush")
+	}
+	if err = wtr.Flush(); err != nil {
+		t.Fatal(err)
+	} else if err = rdr.Refresh(); err != nil {
+		t.Fatal(err)
+	} else if !rdr.Has(0, 0) {
+		t.Fatal("flushed tile not visible to reader")
+	}
+
+	//fill well past the buffer so flushes and sparse index growth happen along the way
+	expected := map[uint64][]byte{tileid(zl, 0, 0): []byte(`buffered`)}
+	dim := 1 << zl
+	for i := 1; i < 5000; i++ {
+		x, y := i/dim, i%dim
+		buff := []byte(fmt.Sprintf("tile %d", i%1000))
+		if err = wtr.Add(x, y, buff); err != nil {
+			t.Fatal(err)
+		}
+		expected[tileid(zl, x, y)] = buff
+	}
+	//delete and overwrite tiles that may still be pending
+	if err = wtr.Delete(39, 0); err != nil {
+		t.Fatal(err)
+	}
+	delete(expected, tileid(zl, 39, 0))
+	if err = wtr.Add(39, 1, []byte(`tile 1`)); err != nil {
+		t.Fatal(err)
+	}
+	expected[tileid(zl, 39, 1)] = []byte(`tile 1`)
+	checkTiles(t, wtr, expected)
+	if st, err := wtr.Stats(); err != nil {
+		t.Fatal(err)
+	} else if st.Tiles != int64(len(expected)) || st.UniqueBlobs != 1001 {
+		t.Fatalf("bad stats with pending tiles %+v", st)
+	}
+	n := 0
+	for range wtr.Tiles(WalkOptions{Order: OrderOffset}) {
+		n++
+	}
+	if n != len(expected) {
+		t.Fatalf("walk with pending tiles returned %d of %d", n, len(expected))
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//everything made it out on close
+	if err = rdr.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	checkTiles(t, rdr, expected)
+	if r, err := rdr.Verify(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	} else if !r.OK() || r.Tiles != int64(len(expected)) {
+		t.Fatalf("bad verify after buffered writes %+v", r)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
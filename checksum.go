@@ -0,0 +1,187 @@
+package tilemap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+)
+
+const (
+	checksumSize   = 4 //CRC32C trailer stored after each blob
+	verifyProgress = 1024
+)
+
+var (
+	ErrChecksum = errors.New("tile checksum mismatch, file may be corrupt")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// TileProblem identifies a tile that failed verification and the blob it references
+type TileProblem struct {
+	X, Y   int
+	Offset int64
+	Size   int64
+}
+
+// VerifyResult reports the outcome of Verify, tiles appear once per problem they have
+type VerifyResult struct {
+	Tiles       int64 //populated tiles checked
+	Blobs       int64 //unique blobs checked
+	Checksums   bool  //false if the file does not carry checksums and only the layout was checked
+	BadChecksum []TileProblem
+	OutOfRange  []TileProblem //datapointers outside of the blob region
+	Overlapping []TileProblem //blobs that overlap another blob or the index
+}
+
+// OK returns true if verification found no problems
+func (r *VerifyResult) OK() bool {
+	return len(r.BadChecksum) == 0 && len(r.OutOfRange) == 0 && len(r.Overlapping) == 0
+}
+
+// VerifyProgress is called periodically by Verify with the number of unique blobs checked so far
+type VerifyProgress func(done, total int64)
+
+func checksum(b []byte) uint32 {
+	return crc32.Checksum(b, crcTable)
+}
+
+// trailer returns the number of bytes stored after each blob
+func (w *Tilemap) trailer() int64 {
+	if w.hdr.Checksums {
+		return checksumSize
+	}
+	return 0
+}
+
+// extent returns the number of bytes a blob occupies in the file
+func (w *Tilemap) extent(dp datapointer) int64 {
+	return dp.size + w.trailer()
+}
+
+// checkBlob compares a blob against the checksum trailer stored after it
+func (w *Tilemap) checkBlob(dp datapointer, b []byte) (err error) {
+	var crc [checksumSize]byte
+	if err = w.readAt(crc[:], dp.offset+dp.size); err != nil {
+		return
+	} else if binary.LittleEndian.Uint32(crc[:]) != checksum(b) {
+		err = ErrChecksum
+	}
+	return
+}
+
+// verifyRead checks a blob that was just read if the tilemap was opened with VerifyReads
+func (w *Tilemap) verifyRead(dp datapointer, b []byte) (err error) {
+	if w.verify && w.hdr.Checksums {
+		err = w.checkBlob(dp, b)
+	}
+	return
+}
+
+type verifyBlob struct {
+	datapointer
+	bad bool
+}
+
+// Verify checks that every blob is in bounds, does not overlap another, and matches its checksum.
+// Cancelling ctx stops the walk, progress may be nil.
+func (w *Tilemap) Verify(ctx context.Context, progress VerifyProgress) (r VerifyResult, err error) {
+	w.RLock()
+	defer w.RUnlock()
+	r.Checksums = w.hdr.Checksums
+
+	//gather the unique blobs, problems are attributed to tiles in a second pass
+	blobs := make(map[int64]*verifyBlob)
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		r.Tiles++
+		if !w.validDataPointer(dp) {
+			return nil
+		} else if b, ok := blobs[dp.offset]; !ok {
+			blobs[dp.offset] = &verifyBlob{datapointer: dp}
+		} else if b.size != dp.size {
+			b.bad = true //same start, different lengths
+			if dp.size > b.size {
+				b.size = dp.size
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	sorted := make([]*verifyBlob, 0, len(blobs))
+	for _, b := range blobs {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].offset < sorted[j].offset
+	})
+	r.Blobs = int64(len(sorted))
+
+	//sparse index tables live among the blobs, the current one must not be overlapped
+	var idxStart, idxEnd int64
+	if w.hdr.Index == IndexSparse {
+		idxStart = w.hdr.idxOffset
+		idxEnd = idxStart + w.hdr.indexSize()
+	}
+	var end int64
+	var owner *verifyBlob //the blob that reaches end
+	for _, b := range sorted {
+		if b.offset < end {
+			b.bad = true
+			owner.bad = true
+		}
+		if e := b.offset + w.extent(b.datapointer); e > end {
+			end = e
+			owner = b
+		}
+		if b.offset < idxEnd && b.offset+w.extent(b.datapointer) > idxStart {
+			b.bad = true
+		}
+	}
+
+	badSum := make(map[int64]es)
+	if r.Checksums {
+		var buff []byte
+		for i, b := range sorted {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			if int64(cap(buff)) < b.size {
+				buff = make([]byte, b.size)
+			}
+			buff = buff[:b.size]
+			if err = w.readAt(buff, b.offset); err != nil {
+				err = errorLine(err)
+				return
+			} else if w.checkBlob(b.datapointer, buff) != nil {
+				badSum[b.offset] = es{}
+			}
+			if progress != nil && (i+1)%verifyProgress == 0 {
+				progress(int64(i+1), r.Blobs)
+			}
+		}
+	}
+	if progress != nil {
+		progress(r.Blobs, r.Blobs)
+	}
+
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		x, y := w.tilexy(tid)
+		p := TileProblem{X: x, Y: y, Offset: dp.offset, Size: dp.size}
+		if !w.validDataPointer(dp) {
+			r.OutOfRange = append(r.OutOfRange, p)
+			return nil
+		}
+		if b, ok := blobs[dp.offset]; ok && b.bad {
+			r.Overlapping = append(r.Overlapping, p)
+		}
+		if _, ok := badSum[dp.offset]; ok {
+			r.BadChecksum = append(r.BadChecksum, p)
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,151 @@
+package tilemap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTilemapVerify(t *testing.T) {
+	zl := 3
+	pth := filepath.Join(tdir, `verify`)
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if !wtr.Header().Checksums {
+		t.Fatal("checksums were not recorded in the header")
+	}
+	shared := randTile(basicBuff)
+	if err = wtr.Add(0, 0, shared); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(0, 1, shared); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(1, 0, randTile(basicBuff)); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(1, 1, randTile(basicBuff)); err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	r, err := wtr.Verify(context.Background(), func(done, total int64) {
+		calls++
+		if done != total || total != 3 {
+			t.Fatalf("bad progress %d/%d", done, total)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if !r.OK() || !r.Checksums || r.Tiles != 4 || r.Blobs != 3 || calls != 1 {
+		t.Fatalf("bad result %+v, %d progress calls", r, calls)
+	}
+
+	//point one tile past the end and another into the middle of an existing blob
+	dp, err := wtr.getDataPointer(tileid(zl, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	} else if err = wtr.setDataPointer(tileid(zl, 2, 2), datapointer{offset: wtr.foff, size: 10}); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.setDataPointer(tileid(zl, 2, 3), datapointer{offset: dp.offset + 10, size: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = wtr.Verify(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	} else if len(r.OutOfRange) != 1 || r.OutOfRange[0].X != 2 || r.OutOfRange[0].Y != 2 {
+		t.Fatalf("bad out of range report %+v", r.OutOfRange)
+	} else if len(r.Overlapping) != 2 {
+		t.Fatalf("bad overlap report %+v", r.Overlapping)
+	}
+	if err = wtr.Delete(2, 2); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Delete(2, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	//two blobs inside a large one, the large one owns the overlap with both
+	if err = wtr.Add(4, 0, basicBuff[1:101]); err != nil {
+		t.Fatal(err)
+	} else if dp, err = wtr.getDataPointer(tileid(zl, 4, 0)); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.setDataPointer(tileid(zl, 4, 1), datapointer{offset: dp.offset + 10, size: 10}); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.setDataPointer(tileid(zl, 4, 2), datapointer{offset: dp.offset + 50, size: 10}); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(4, 3, randTile(basicBuff)); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = wtr.Verify(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	} else if len(r.Overlapping) != 3 {
+		t.Fatalf("bad nested overlap report %+v", r.Overlapping)
+	}
+	for _, p := range r.Overlapping {
+		if p.X != 4 || p.Y > 2 {
+			t.Fatalf("bad nested overlap report %+v", r.Overlapping)
+		}
+	}
+	for y := 0; y < 4; y++ {
+		if err = wtr.Delete(4, y); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//compaction carries the checksums across
+	if err = wtr.Compact(); err != nil {
+		t.Fatal(err)
+	} else if r, err = wtr.Verify(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	} else if !r.OK() || r.Tiles != 4 {
+		t.Fatalf("bad result after compaction %+v", r)
+	}
+	if dp, err = wtr.getDataPointer(tileid(zl, 0, 0)); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//flip a byte in the shared blob
+	fio, err := os.OpenFile(pth, os.O_RDWR, 0640)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = fio.WriteAt([]byte{^shared[5]}, dp.offset+5); err != nil {
+		t.Fatal(err)
+	} else if err = fio.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//unverified reads hand back whatever is on disk
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = rdr.GetTile(0, 0); err != nil {
+		t.Fatal(err)
+	} else if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err = NewTilemapConfig(pth, Config{Zoom: AnyZoom, ReadOnly: true, VerifyReads: true, MapData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.GetTile(0, 0); err != ErrChecksum {
+		t.Fatalf("Failed to catch bad checksum: %v", err)
+	} else if _, err = rdr.GetTileView(0, 1); err != ErrChecksum {
+		t.Fatalf("Failed to catch bad checksum on a view: %v", err)
+	} else if _, err = rdr.GetTile(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = rdr.Verify(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	} else if len(r.BadChecksum) != 2 || len(r.OutOfRange) != 0 || len(r.Overlapping) != 0 {
+		t.Fatalf("bad checksum report %+v", r)
+	}
+
+	ctx, cf := context.WithCancel(context.Background())
+	cf()
+	if _, err = rdr.Verify(ctx, nil); err != context.Canceled {
+		t.Fatalf("cancelled verify returned %v", err)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
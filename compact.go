@@ -0,0 +1,204 @@
+package tilemap
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	compactSuffix = `.compact`
+)
+
+// DeadBytes returns the number of bytes held by blobs that are no longer referenced
+// by any tile.  Replaced and deleted tiles leave dead bytes behind until Compact is called.
+func (w *Tilemap) DeadBytes() (n int64, err error) {
+	w.RLock()
+	defer w.RUnlock()
+	live := w.live
+	if w.refs == nil {
+		if live, err = w.scanLiveBytes(); err != nil {
+			return
+		}
+	}
+	n = w.deadBytes(live)
+	return
+}
+
+// deadBytes returns the bytes in the blob region that are not held by live blobs
+func (w *Tilemap) deadBytes(live int64) (n int64) {
+	n = w.foff - w.dataStart - live
+	if w.hdr.Index == IndexSparse {
+		n -= w.hdr.indexSize() //the current table lives among the blobs, older tables are dead
+	}
+	return
+}
+
+// scanLiveBytes walks the index and totals the size of every referenced blob
+func (w *Tilemap) scanLiveBytes() (live int64, err error) {
+	seen := make(map[int64]es)
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		if _, ok := seen[dp.offset]; !ok && w.validDataPointer(dp) {
+			seen[dp.offset] = es{}
+			live += w.extent(dp)
+		}
+		return nil
+	})
+	return
+}
+
+// Compact rewrites every live blob into a new file and atomically swaps it in place of the existing file
+func (w *Tilemap) Compact() (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.ro {
+		err = ErrReadOnly
+		return
+	}
+	if err = w.flush(); err != nil {
+		return
+	}
+	var nw *Tilemap
+	var remap map[int64]int64
+	if _, ok := w.st.(*fileStorage); !ok {
+		//nobody else can see a memory tilemap, just build the new one alongside it
+		if nw, remap, err = w.compactTo(&memStorage{}); err != nil {
+			return
+		}
+	} else if nw, remap, err = w.compactFile(); err != nil {
+		return
+	}
+
+	//swap the new file in and carry the dedup state across
+	if err = w.swap(nw); err != nil {
+		err = errorLine(err)
+		return
+	}
+	if w.hmp != nil {
+		hmp := make(map[uint64]datapointer, len(w.hmp))
+		for k, dp := range w.hmp {
+			if off, ok := remap[dp.offset]; ok {
+				hmp[k] = datapointer{offset: off, size: dp.size}
+			}
+		}
+		hcol := make(map[uint64][]datapointer, len(w.hcol))
+		for k, dps := range w.hcol {
+			for _, dp := range dps {
+				if off, ok := remap[dp.offset]; ok {
+					hcol[k] = append(hcol[k], datapointer{offset: off, size: dp.size})
+				}
+			}
+		}
+		refs := make(map[int64]uint32, len(w.refs))
+		for off, cnt := range w.refs {
+			if noff, ok := remap[off]; ok {
+				refs[noff] = cnt
+			}
+		}
+		w.hmp = hmp
+		w.hcol = hcol
+		w.refs = refs
+	}
+	return
+}
+
+// compactFile compacts into a temporary file and renames it over the tilemap file
+func (w *Tilemap) compactFile() (nw *Tilemap, remap map[int64]int64, err error) {
+	tpth := w.pth + compactSuffix
+	var st *fileStorage
+	if st, err = openFileStorage(tpth, false); err != nil {
+		return
+	} else if err = st.fio.Truncate(0); err != nil {
+		st.close()
+		os.Remove(tpth)
+		return
+	}
+	if nw, remap, err = w.compactTo(st); err != nil {
+		os.Remove(tpth)
+		return
+	}
+	if err = os.Rename(tpth, w.pth); err != nil {
+		nw.Close()
+		os.Remove(tpth)
+		nw = nil
+		err = errorLine(err)
+		return
+	}
+	//best effort, the rename is already visible
+	syncDir(filepath.Dir(w.pth))
+	return
+}
+
+// compactTo copies the live tiles into a new tilemap with the same header held in empty storage.
+// The storage is consumed and the returned remap translates old blob offsets into new blob offsets.
+func (w *Tilemap) compactTo(st storage) (nw *Tilemap, remap map[int64]int64, err error) {
+	hdr := w.hdr
+	if hdr.Index == IndexSparse {
+		//size the new table for the tiles we have and put it back at the front of the file
+		var n int64
+		if err = w.walkIndex(func(uint64, datapointer) error {
+			n++
+			return nil
+		}); err != nil {
+			st.close()
+			return
+		}
+		hdr.idxOffset = headerSize
+		hdr.idxSlots = sparseSlots(n)
+	}
+	if hdrSize := hdr.size(); hdrSize > 0 {
+		hdr.Dirty = false //the new file is clean, there is nothing to recover
+		buff := make([]byte, hdrSize)
+		if err = hdr.Encode(buff); err != nil {
+			st.close()
+			return
+		} else if _, err = st.WriteAt(buff, 0); err != nil {
+			st.close()
+			return
+		}
+	}
+	//preallocate the index so legacy files remain legacy files
+	if _, err = prepStorage(st, hdr.indexOffset()+hdr.indexSize()); err != nil {
+		st.close()
+		return
+	} else if nw, err = newTilemap(st, Config{Zoom: w.zoom, Hash: w.hash}); err != nil {
+		st.close()
+		return
+	}
+
+	remap = make(map[int64]int64)
+	var buff []byte
+	err = w.walkIndex(func(tid uint64, dp datapointer) (lerr error) {
+		if !w.validDataPointer(dp) {
+			return //drop anything that does not point at real data
+		}
+		ndp := datapointer{size: dp.size}
+		if off, ok := remap[dp.offset]; ok {
+			ndp.offset = off
+		} else {
+			//copy the checksum along with the blob rather than computing a fresh one
+			ext := w.extent(dp)
+			if int64(cap(buff)) < ext {
+				buff = make([]byte, ext)
+			}
+			buff = buff[:ext]
+			if lerr = w.readAt(buff, dp.offset); lerr != nil {
+				return
+			} else if ndp, lerr = nw.writeBlob(buff, dp.size); lerr != nil {
+				return
+			}
+			remap[dp.offset] = ndp.offset
+		}
+		return nw.setDataPointer(tid, ndp)
+	})
+	if err == nil {
+		if err = nw.syncIndex(); err == nil {
+			err = nw.st.sync()
+		}
+	}
+	if err != nil {
+		nw.Close()
+		nw = nil
+		err = errorLine(err)
+	}
+	return
+}
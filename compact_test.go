@@ -0,0 +1,139 @@
+package tilemap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTilemapDeleteCompact(t *testing.T) {
+	zl := 3
+	pth := filepath.Join(tdir, `compact`)
+	wtr, err := NewTilemap(pth, zl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := []byte(`shared blank tile`)
+	dim := 1 << uint(zl)
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			buff := shared
+			if j%2 == 0 {
+				buff = []byte(fmt.Sprintf("first %d %d", i, j))
+			}
+			if err = wtr.Add(i, j, buff); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n, err := wtr.DeadBytes(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("fresh tilemap has %d dead bytes", n)
+	}
+
+	//replace every unique tile and delete a couple
+	expected := make(map[uint64][]byte)
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			if j%2 == 0 {
+				buff := []byte(fmt.Sprintf("second %d %d", i, j))
+				if err = wtr.Add(i, j, buff); err != nil {
+					t.Fatal(err)
+				}
+				expected[tileid(zl, i, j)] = buff
+			} else {
+				expected[tileid(zl, i, j)] = shared
+			}
+		}
+	}
+	if err = wtr.Delete(0, 1); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Delete(0, 2); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Delete(0, 2); err != ErrTileNotFound {
+		t.Fatalf("Failed to catch double delete: %v", err)
+	}
+	delete(expected, tileid(zl, 0, 1))
+	delete(expected, tileid(zl, 0, 2))
+
+	dead, err := wtr.DeadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantDead int64
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j += 2 {
+			wantDead += int64(len(fmt.Sprintf("first %d %d", i, j)))
+		}
+	}
+	wantDead += int64(len(`second 0 2`))
+	if dead != wantDead {
+		t.Fatalf("bad dead bytes %d != %d", dead, wantDead)
+	}
+	fi, err := os.Stat(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = wtr.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := wtr.DeadBytes(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("compacted tilemap has %d dead bytes", n)
+	}
+	if fi2, err := os.Stat(pth); err != nil {
+		t.Fatal(err)
+	} else if fi.Size()-fi2.Size() != wantDead {
+		t.Fatalf("compaction reclaimed %d bytes, expected %d", fi.Size()-fi2.Size(), wantDead)
+	}
+	checkTiles(t, wtr, expected)
+
+	//sharing is preserved and dedup still works against the new file
+	foff := wtr.foff
+	if err = wtr.Add(0, 1, shared); err != nil {
+		t.Fatal(err)
+	} else if wtr.foff != foff {
+		t.Fatal("shared tile was rewritten after compaction")
+	}
+	expected[tileid(zl, 0, 1)] = shared
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := NewTilemap(pth, zl, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTiles(t, rdr, expected)
+	if n, err := rdr.DeadBytes(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("reopened tilemap has %d dead bytes", n)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTiles(t *testing.T, tm *Tilemap, expected map[uint64][]byte) {
+	dim := 1 << uint(tm.Zoom())
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			want, ok := expected[tileid(tm.Zoom(), i, j)]
+			buff, err := tm.GetTile(i, j)
+			if !ok {
+				if err != ErrTileNotFound {
+					t.Fatalf("tile %d %d should be missing: %v", i, j, err)
+				}
+			} else if err != nil {
+				t.Fatalf("tile %d %d: %v", i, j, err)
+			} else if string(buff) != string(want) {
+				t.Fatalf("bad tile %d %d: %q != %q", i, j, buff, want)
+			}
+		}
+	}
+}
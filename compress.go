@@ -0,0 +1,156 @@
+package tilemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how blobs are stored in a tilemap file
+type Compression uint8
+
+const (
+	CompressNone Compression = 0
+	CompressGzip Compression = 1
+	CompressZstd Compression = 2
+)
+
+var (
+	ErrInvalidCompression = errors.New("invalid tile compression")
+
+	gzipWriters = sync.Pool{
+		New: func() interface{} {
+			return gzip.NewWriter(nil)
+		},
+	}
+
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return `none`
+	case CompressGzip:
+		return `gzip`
+	case CompressZstd:
+		return `zstd`
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(c))
+}
+
+// Encoding returns the HTTP Content-Encoding token for the compression, empty for CompressNone
+func (c Compression) Encoding() string {
+	switch c {
+	case CompressGzip:
+		return `gzip`
+	case CompressZstd:
+		return `zstd`
+	}
+	return ``
+}
+
+// ParseCompression converts a name from String back to a Compression
+func ParseCompression(s string) (c Compression, err error) {
+	switch s {
+	case ``, `none`:
+		c = CompressNone
+	case `gzip`:
+		c = CompressGzip
+	case `zstd`:
+		c = CompressZstd
+	default:
+		err = ErrInvalidCompression
+	}
+	return
+}
+
+func (c Compression) valid() bool {
+	return c <= CompressZstd
+}
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEnc, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDec, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxTileSize))
+	})
+	return zstdErr
+}
+
+// compress returns the stored form of a tile
+func (c Compression) compress(b []byte) (r []byte, err error) {
+	switch c {
+	case CompressNone:
+		r = b
+	case CompressGzip:
+		var bb bytes.Buffer
+		gw := gzipWriters.Get().(*gzip.Writer)
+		gw.Reset(&bb)
+		if _, err = gw.Write(b); err == nil {
+			err = gw.Close()
+		}
+		gzipWriters.Put(gw)
+		r = bb.Bytes()
+	case CompressZstd:
+		if err = initZstd(); err == nil {
+			r = zstdEnc.EncodeAll(b, nil)
+		}
+	default:
+		err = ErrInvalidCompression
+	}
+	return
+}
+
+// decompress returns the original tile from its stored form, tiles are never larger than maxTileSize
+func (c Compression) decompress(b []byte) (r []byte, err error) {
+	switch c {
+	case CompressNone:
+		r = b
+	case CompressGzip:
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(bytes.NewReader(b)); err != nil {
+			return
+		}
+		if r, err = io.ReadAll(io.LimitReader(gr, maxTileSize+1)); err == nil && len(r) > maxTileSize {
+			err = ErrInvalidTileBuffer
+		}
+	case CompressZstd:
+		if err = initZstd(); err == nil {
+			r, err = zstdDec.DecodeAll(b, nil)
+		}
+	default:
+		err = ErrInvalidCompression
+	}
+	return
+}
+
+// Compression returns the compression used to store tiles
+func (w *Tilemap) Compression() Compression {
+	w.RLock()
+	defer w.RUnlock()
+	return w.hdr.Compression
+}
+
+// GetRawTile returns the tile at x, y as stored, without decompressing it
+func (w *Tilemap) GetRawTile(x, y int) (buff []byte, err error) {
+	w.RLock()
+	defer w.RUnlock()
+	buff, err = w.getRaw(x, y)
+	return
+}
+
+// WriteRawTileTo writes the tile at x, y to wtr as stored, which lets an HTTP server hand
+// compressed tiles to clients that accept the encoding returned by Compression
+func (w *Tilemap) WriteRawTileTo(x, y int, wtr io.Writer) (n int64, err error) {
+	return w.writeTo(x, y, wtr, true)
+}
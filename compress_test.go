@@ -0,0 +1,114 @@
+package tilemap
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTilemapCompression(t *testing.T) {
+	for _, c := range []Compression{CompressGzip, CompressZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			testCompression(t, c)
+		})
+	}
+	if _, err := ParseCompression(`lzma`); err != ErrInvalidCompression {
+		t.Fatalf("Failed to catch bad compression: %v", err)
+	}
+	h := Header{Version: headerVersion, MimeType: DefaultMimeType, Compression: CompressZstd + 1}
+	if err := h.Encode(make([]byte, headerSize)); err != ErrInvalidCompression {
+		t.Fatalf("Failed to catch bad header compression: %v", err)
+	}
+}
+
+func testCompression(t *testing.T, c Compression) {
+	if pc, err := ParseCompression(c.String()); err != nil || pc != c {
+		t.Fatalf("bad compression round trip %v %v", pc, err)
+	}
+	zl := 2
+	pth := filepath.Join(tdir, `compress`+c.String())
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Compression: c, Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile := []byte(strings.Repeat(`{"type":"Feature","geometry":null}`, 100))
+	other := []byte(strings.Repeat(`{"type":"Point"}`, 100))
+	if err = wtr.Add(0, 0, tile); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(0, 1, other); err != nil {
+		t.Fatal(err)
+	}
+	if sz, err := wtr.TileSize(0, 0); err != nil {
+		t.Fatal(err)
+	} else if sz >= int64(len(tile)) {
+		t.Fatalf("tile was not compressed: %d >= %d", sz, len(tile))
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//dedup has to see through the compression after a reopen
+	if wtr, err = OpenTilemap(pth, false); err != nil {
+		t.Fatal(err)
+	} else if wtr.Compression() != c {
+		t.Fatalf("bad compression %v", wtr.Compression())
+	}
+	sz := wtr.size()
+	if err = wtr.Add(1, 1, tile); err != nil {
+		t.Fatal(err)
+	} else if wtr.size() != sz {
+		t.Fatal("compressed tile was not deduplicated")
+	} else if err = wtr.Compact(); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := NewTilemapConfig(pth, Config{Zoom: AnyZoom, ReadOnly: true, MapData: true, VerifyReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, xy := range [][2]int{{0, 0}, {1, 1}} {
+		if buff, err := rdr.GetTile(xy[0], xy[1]); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buff, tile) {
+			t.Fatalf("bad tile at %v", xy)
+		}
+	}
+	if view, err := rdr.GetTileView(0, 1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(view, other) {
+		t.Fatal("bad tile view")
+	}
+	var bb bytes.Buffer
+	if _, err = rdr.WriteTileTo(0, 1, &bb); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(bb.Bytes(), other) {
+		t.Fatal("bad WriteTileTo")
+	}
+
+	//raw access hands out the stored bytes
+	bb.Reset()
+	raw, err := rdr.GetRawTile(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = rdr.WriteRawTileTo(0, 0, &bb); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(raw, bb.Bytes()) {
+		t.Fatal("raw tile mismatch")
+	} else if buff, err := c.decompress(raw); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buff, tile) {
+		t.Fatal("raw tile does not decompress to the original")
+	}
+	if r, err := rdr.Verify(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	} else if !r.OK() {
+		t.Fatalf("bad verify %+v", r)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
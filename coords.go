@@ -0,0 +1,159 @@
+package tilemap
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidQuadkey = errors.New("invalid quadkey")
+)
+
+// TileCoord addresses a tile with XYZ (slippy map) coordinates, which is what the rest of the
+// package uses.  Y runs from the north edge of the map down to the south.
+type TileCoord struct {
+	Zoom, X, Y int
+}
+
+// TMSCoord addresses a tile with TMS coordinates, y runs from the south edge of the map up
+type TMSCoord struct {
+	Zoom, X, Y int
+}
+
+// Valid returns true if the tile lies within the map at its zoom
+func (c TileCoord) Valid() bool {
+	dim := 1 << uint(c.Zoom)
+	return c.Zoom >= 0 && c.Zoom <= MaxZoom && c.X >= 0 && c.X < dim && c.Y >= 0 && c.Y < dim
+}
+
+// TMS converts the coordinate to TMS
+func (c TileCoord) TMS() TMSCoord {
+	return TMSCoord{Zoom: c.Zoom, X: c.X, Y: flipY(c.Zoom, c.Y)}
+}
+
+// XYZ converts the coordinate to XYZ
+func (c TMSCoord) XYZ() TileCoord {
+	return TileCoord{Zoom: c.Zoom, X: c.X, Y: flipY(c.Zoom, c.Y)}
+}
+
+// flipY converts a y value between XYZ and TMS, the conversion is the same in both directions
+func flipY(zoom, y int) int {
+	return (1 << uint(zoom)) - 1 - y
+}
+
+// Quadkey returns the Bing maps quadkey of the tile, one base 4 digit per zoom level.
+// The single tile at zoom 0 has an empty quadkey.
+func (c TileCoord) Quadkey() string {
+	var sb strings.Builder
+	sb.Grow(c.Zoom)
+	for i := c.Zoom; i > 0; i-- {
+		digit := byte('0')
+		mask := 1 << uint(i-1)
+		if c.X&mask != 0 {
+			digit++
+		}
+		if c.Y&mask != 0 {
+			digit += 2
+		}
+		sb.WriteByte(digit)
+	}
+	return sb.String()
+}
+
+// ParseQuadkey converts a quadkey back to an XYZ coordinate, the zoom is the length of the key
+func ParseQuadkey(qk string) (c TileCoord, err error) {
+	if len(qk) > MaxZoom {
+		err = ErrInvalidQuadkey
+		return
+	}
+	c.Zoom = len(qk)
+	for i := 0; i < len(qk); i++ {
+		mask := 1 << uint(c.Zoom-i-1)
+		switch qk[i] {
+		case '0':
+		case '1':
+			c.X |= mask
+		case '2':
+			c.Y |= mask
+		case '3':
+			c.X |= mask
+			c.Y |= mask
+		default:
+			err = ErrInvalidQuadkey
+			return
+		}
+	}
+	return
+}
+
+// quadkeyXY parses a quadkey for a tilemap, the key has to be for the tilemap zoom
+func (w *Tilemap) quadkeyXY(qk string) (x, y int, err error) {
+	var c TileCoord
+	if c, err = ParseQuadkey(qk); err != nil {
+		return
+	} else if c.Zoom != w.zoom {
+		err = ErrZoomMismatch
+		return
+	}
+	x, y = c.X, c.Y
+	return
+}
+
+// GetTileTMS returns the tile at the TMS coordinates x, y
+func (w *Tilemap) GetTileTMS(x, y int) ([]byte, error) {
+	return w.GetTile(x, flipY(w.zoom, y))
+}
+
+// AddTMS adds a tile at the TMS coordinates x, y
+func (w *Tilemap) AddTMS(x, y int, buff []byte) error {
+	return w.Add(x, flipY(w.zoom, y), buff)
+}
+
+// GetTileQuadkey returns the tile addressed by a quadkey, ErrZoomMismatch is returned if
+// the quadkey is for a different zoom level
+func (w *Tilemap) GetTileQuadkey(qk string) (buff []byte, err error) {
+	var x, y int
+	if x, y, err = w.quadkeyXY(qk); err == nil {
+		buff, err = w.GetTile(x, y)
+	}
+	return
+}
+
+// AddQuadkey adds a tile addressed by a quadkey
+func (w *Tilemap) AddQuadkey(qk string, buff []byte) (err error) {
+	var x, y int
+	if x, y, err = w.quadkeyXY(qk); err == nil {
+		err = w.Add(x, y, buff)
+	}
+	return
+}
+
+// GetTileTMS returns the tile at a TMS coordinate
+func (ts *Tileset) GetTileTMS(c TMSCoord) ([]byte, error) {
+	xyz := c.XYZ()
+	return ts.GetTile(xyz.Zoom, xyz.X, xyz.Y)
+}
+
+// AddTMS adds a tile at a TMS coordinate
+func (ts *Tileset) AddTMS(c TMSCoord, buff []byte) error {
+	xyz := c.XYZ()
+	return ts.Add(xyz.Zoom, xyz.X, xyz.Y, buff)
+}
+
+// GetTileQuadkey returns the tile addressed by a quadkey
+func (ts *Tileset) GetTileQuadkey(qk string) (buff []byte, err error) {
+	var c TileCoord
+	if c, err = ParseQuadkey(qk); err == nil {
+		buff, err = ts.GetTile(c.Zoom, c.X, c.Y)
+	}
+	return
+}
+
+// AddQuadkey adds a tile addressed by a quadkey
+func (ts *Tileset) AddQuadkey(qk string, buff []byte) (err error) {
+	var c TileCoord
+	if c, err = ParseQuadkey(qk); err == nil {
+		err = ts.Add(c.Zoom, c.X, c.Y, buff)
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package tilemap
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCoords(t *testing.T) {
+	//reference values from the Bing maps tile system documentation
+	c := TileCoord{Zoom: 3, X: 3, Y: 5}
+	if qk := c.Quadkey(); qk != `213` {
+		t.Fatalf("bad quadkey %q", qk)
+	} else if tc := c.TMS(); tc != (TMSCoord{Zoom: 3, X: 3, Y: 2}) {
+		t.Fatalf("bad TMS %+v", tc)
+	} else if tc.XYZ() != c {
+		t.Fatalf("bad TMS round trip %+v", tc.XYZ())
+	}
+	if root := (TileCoord{}); root.Quadkey() != `` {
+		t.Fatal("zoom 0 has a quadkey")
+	} else if pc, err := ParseQuadkey(``); err != nil || pc != root {
+		t.Fatalf("bad root quadkey %+v %v", pc, err)
+	}
+	for zl := 0; zl <= 5; zl++ {
+		dim := 1 << uint(zl)
+		for x := 0; x < dim; x++ {
+			for y := 0; y < dim; y++ {
+				c := TileCoord{Zoom: zl, X: x, Y: y}
+				if pc, err := ParseQuadkey(c.Quadkey()); err != nil || pc != c {
+					t.Fatalf("bad quadkey round trip %+v %+v %v", c, pc, err)
+				} else if !c.TMS().XYZ().Valid() {
+					t.Fatalf("TMS of %+v is invalid", c)
+				}
+			}
+		}
+	}
+	for _, qk := range []string{`0124`, `x`, `0123012301230123012301230`} {
+		if _, err := ParseQuadkey(qk); err != ErrInvalidQuadkey {
+			t.Fatalf("Failed to catch bad quadkey %q: %v", qk, err)
+		}
+	}
+	if (TileCoord{Zoom: 2, X: 4}).Valid() {
+		t.Fatal("Failed to catch invalid coordinate")
+	}
+}
+
+func TestCoordsAccess(t *testing.T) {
+	ts, err := OpenTileset(filepath.Join(tdir, `coords`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.AddTMS(TMSCoord{Zoom: 3, X: 3, Y: 2}, []byte(`tms`)); err != nil {
+		t.Fatal(err)
+	} else if err = ts.AddQuadkey(`0`, []byte(`quadkey`)); err != nil {
+		t.Fatal(err)
+	} else if err = ts.AddQuadkey(`04`, []byte(`bad`)); err != ErrInvalidQuadkey {
+		t.Fatalf("Failed to catch bad quadkey: %v", err)
+	}
+	if buff, err := ts.GetTile(3, 3, 5); err != nil || string(buff) != `tms` {
+		t.Fatalf("TMS tile in the wrong place %q %v", buff, err)
+	} else if buff, err = ts.GetTileQuadkey(`213`); err != nil || string(buff) != `tms` {
+		t.Fatalf("bad quadkey read %q %v", buff, err)
+	} else if buff, err = ts.GetTileTMS(TMSCoord{Zoom: 1, X: 0, Y: 1}); err != nil || string(buff) != `quadkey` {
+		t.Fatalf("bad TMS read %q %v", buff, err)
+	}
+
+	tm := ts.Tilemap(3)
+	if err = tm.AddQuadkey(`000`, []byte(`corner`)); err != nil {
+		t.Fatal(err)
+	} else if err = tm.AddQuadkey(`00`, []byte(`short`)); err != ErrZoomMismatch {
+		t.Fatalf("Failed to catch quadkey for the wrong zoom: %v", err)
+	} else if err = tm.AddTMS(0, 0, []byte(`bottom`)); err != nil {
+		t.Fatal(err)
+	}
+	if buff, err := tm.GetTileTMS(0, 7); err != nil || string(buff) != `corner` {
+		t.Fatalf("bad tilemap TMS read %q %v", buff, err)
+	} else if buff, err = tm.GetTileQuadkey(`222`); err != nil || string(buff) != `bottom` {
+		t.Fatalf("bad tilemap quadkey read %q %v", buff, err)
+	} else if _, err = tm.GetTileTMS(0, 8); err != ErrInvalidTileID {
+		t.Fatalf("Failed to catch TMS tile outside the map: %v", err)
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,214 @@
+package tilemap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Curve selects how tile coordinates map to tile ids and so where tiles sit in the index
+type Curve uint8
+
+const (
+	CurveRowMajor Curve = 0 //x * dim + y, used by legacy files
+	CurveMorton   Curve = 1 //Z-order, x and y bits interleaved
+	CurveHilbert  Curve = 2 //Hilbert curve, best locality
+
+	rewriteBatch = 1024 //tiles per AddBatch when rewriting
+)
+
+var (
+	ErrInvalidCurve = errors.New("invalid tile id curve")
+)
+
+func (c Curve) String() string {
+	switch c {
+	case CurveRowMajor:
+		return `rowmajor`
+	case CurveMorton:
+		return `morton`
+	case CurveHilbert:
+		return `hilbert`
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(c))
+}
+
+// ParseCurve converts a name from String back to a Curve
+func ParseCurve(s string) (c Curve, err error) {
+	switch s {
+	case ``, `rowmajor`:
+		c = CurveRowMajor
+	case `morton`:
+		c = CurveMorton
+	case `hilbert`:
+		c = CurveHilbert
+	default:
+		err = ErrInvalidCurve
+	}
+	return
+}
+
+func (c Curve) valid() bool {
+	return c <= CurveHilbert
+}
+
+// TileID returns the id of the tile at x, y along the curve.
+// Walking ids from zero through 4^zoom - 1 visits every tile in curve order.
+func (c Curve) TileID(zoom, x, y int) uint64 {
+	switch c {
+	case CurveMorton:
+		return spreadBits(uint64(x))<<1 | spreadBits(uint64(y))
+	case CurveHilbert:
+		return hilbertID(zoom, x, y)
+	}
+	return tileid(zoom, x, y)
+}
+
+// TileXY is the inverse of TileID
+func (c Curve) TileXY(zoom int, tid uint64) (x, y int) {
+	switch c {
+	case CurveMorton:
+		return int(compactBits(tid >> 1)), int(compactBits(tid))
+	case CurveHilbert:
+		return hilbertXY(zoom, tid)
+	}
+	return tilexy(zoom, tid)
+}
+
+// spreadBits moves the low 32 bits of v into the even bit positions
+func spreadBits(v uint64) uint64 {
+	v &= 0xffffffff
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// compactBits is the inverse of spreadBits
+func compactBits(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return v
+}
+
+func hilbertID(zoom, x, y int) (d uint64) {
+	n := 1 << uint(zoom)
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry int
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		d += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		x, y = hilbertRotate(n, x, y, rx, ry)
+	}
+	return
+}
+
+func hilbertXY(zoom int, d uint64) (x, y int) {
+	n := 1 << uint(zoom)
+	for s := 1; s < n; s *= 2 {
+		rx := int(1 & (d / 2))
+		ry := int(1 & (d ^ uint64(rx)))
+		x, y = hilbertRotate(s, x, y, rx, ry)
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return
+}
+
+func hilbertRotate(n, x, y, rx, ry int) (int, int) {
+	if ry == 0 {
+		if rx == 1 {
+			x = n - 1 - x
+			y = n - 1 - y
+		}
+		x, y = y, x
+	}
+	return x, y
+}
+
+// inheritConfig fills the settings c leaves empty from hdr so a new tilemap matches its source
+func inheritConfig(hdr Header, c Config) Config {
+	c.Zoom = hdr.Zoom
+	c.ReadOnly = false
+	if c.MimeType == `` && c.Format == FormatUnknown {
+		c.MimeType = hdr.MimeType
+		c.Format = hdr.Format
+	}
+	if c.TileSize == 0 {
+		c.TileSize = hdr.TileSize
+	}
+	if c.Metadata.Name == `` && c.Metadata.Attribution == `` && c.Metadata.Extra == nil {
+		c.Metadata = hdr.Metadata
+	}
+	if !c.Region.Set() {
+		c.Region = hdr.Region
+	}
+	return c
+}
+
+// ensureAbsent returns an error if something already exists at pth
+func ensureAbsent(pth string) (err error) {
+	if _, err = os.Stat(pth); err == nil {
+		err = fmt.Errorf("%s already exists", pth)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// Rewrite copies every tile in src into a new tilemap at pth created with c, in the new curve order.
+// Settings c leaves empty come from src and a smaller Region crops the map.
+func Rewrite(src *Tilemap, pth string, c Config) (err error) {
+	hdr := src.Header()
+	c = inheritConfig(hdr, c)
+	if err = ensureAbsent(pth); err != nil {
+		return
+	}
+	var dst *Tilemap
+	if dst, err = NewTilemapConfig(pth, c); err != nil {
+		return
+	}
+
+	//order the source tiles along the destination curve, only the tile ids are held
+	var tids []uint64
+	bounds := c.Region.Bounds(hdr.Zoom)
+	for t := range src.Tiles(WalkOptions{Bounds: &bounds}) {
+		tids = append(tids, c.Region.tileid(c.Curve, hdr.Zoom, t.X, t.Y))
+	}
+	slices.Sort(tids)
+	batch := make([]Tile, 0, rewriteBatch)
+	for i, tid := range tids {
+		var buff []byte
+		x, y := c.Region.tilexy(c.Curve, hdr.Zoom, tid)
+		if buff, err = src.GetTile(x, y); err != nil {
+			err = fmt.Errorf("Failed to read tile %d/%d: %v", x, y, err)
+			break
+		}
+		batch = append(batch, Tile{X: x, Y: y, Data: buff})
+		if len(batch) == rewriteBatch || i == len(tids)-1 {
+			if err = dst.AddBatch(batch); err != nil {
+				break
+			}
+			batch = batch[:0]
+		}
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(pth)
+		return
+	}
+	err = dst.Close()
+	return
+}
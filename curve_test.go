@@ -0,0 +1,140 @@
+package tilemap
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestCurve(t *testing.T) {
+	for _, c := range []Curve{CurveRowMajor, CurveMorton, CurveHilbert} {
+		if pc, err := ParseCurve(c.String()); err != nil || pc != c {
+			t.Fatalf("bad curve round trip %v %v", pc, err)
+		}
+		for zl := 0; zl <= 6; zl++ {
+			dim := 1 << uint(zl)
+			seen := make(map[uint64]es)
+			for x := 0; x < dim; x++ {
+				for y := 0; y < dim; y++ {
+					tid := c.TileID(zl, x, y)
+					if tid >= uint64(dim*dim) {
+						t.Fatalf("%v zoom %d tile %d %d id %d out of range", c, zl, x, y, tid)
+					} else if _, ok := seen[tid]; ok {
+						t.Fatalf("%v zoom %d duplicate id %d", c, zl, tid)
+					}
+					seen[tid] = es{}
+					if xx, yy := c.TileXY(zl, tid); xx != x || yy != y {
+						t.Fatalf("%v zoom %d id %d gave %d %d != %d %d", c, zl, tid, xx, yy, x, y)
+					}
+				}
+			}
+		}
+	}
+	//every step along a hilbert curve moves to an adjacent tile
+	zl := 6
+	px, py := CurveHilbert.TileXY(zl, 0)
+	for tid := uint64(1); tid < 1<<(2*uint(zl)); tid++ {
+		x, y := CurveHilbert.TileXY(zl, tid)
+		if d := abs(x-px) + abs(y-py); d != 1 {
+			t.Fatalf("hilbert step %d moved %d tiles", tid, d)
+		}
+		px, py = x, y
+	}
+	//large zooms use the full width of the id
+	if x, y := CurveMorton.TileXY(MaxZoom, CurveMorton.TileID(MaxZoom, 4000000, 123)); x != 4000000 || y != 123 {
+		t.Fatalf("bad morton round trip at max zoom %d %d", x, y)
+	}
+	if _, err := ParseCurve(`peano`); err != ErrInvalidCurve {
+		t.Fatalf("Failed to catch bad curve: %v", err)
+	}
+	h := Header{Version: headerVersion, MimeType: DefaultMimeType, Curve: CurveHilbert + 1}
+	if err := h.Encode(make([]byte, headerSize)); err != ErrInvalidCurve {
+		t.Fatalf("Failed to catch bad header curve: %v", err)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestRewrite(t *testing.T) {
+	zl := 5
+	pth := filepath.Join(tdir, `rewrite`)
+	src, err := NewTilemapConfig(pth, Config{Zoom: zl, MimeType: `image/png`, Metadata: Metadata{Name: `rewrite`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[uint64][]byte)
+	for i := 0; i < 700; i += 3 {
+		x, y := i/32, i%32
+		buff := []byte(fmt.Sprintf("tile %d", i%50))
+		if err = src.Add(x, y, buff); err != nil {
+			t.Fatal(err)
+		}
+		expected[tileid(zl, x, y)] = buff
+	}
+
+	for _, c := range []Config{
+		{Curve: CurveHilbert, Compression: CompressZstd, Checksums: true},
+		{Curve: CurveMorton, Index: IndexSparse},
+	} {
+		out := filepath.Join(tdir, `rewrite`+c.Curve.String())
+		if err = Rewrite(src, out, c); err != nil {
+			t.Fatal(err)
+		} else if err = Rewrite(src, out, c); err == nil {
+			t.Fatal("Failed to refuse an existing output")
+		}
+		dst, err := OpenTilemap(out, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr := dst.Header()
+		if hdr.Curve != c.Curve || hdr.Index != c.Index || hdr.Compression != c.Compression || hdr.Checksums != c.Checksums {
+			t.Fatalf("bad header after rewrite %+v", hdr)
+		} else if hdr.MimeType != `image/png` || hdr.Metadata.Name != `rewrite` {
+			t.Fatalf("source header not carried over %+v", hdr)
+		}
+		checkTiles(t, dst, expected)
+		if st, err := dst.Stats(); err != nil {
+			t.Fatal(err)
+		} else if st.UniqueBlobs != 50 || st.DeadBytes != 0 {
+			t.Fatalf("bad stats after rewrite %+v", st)
+		}
+		//tile id order follows the curve and blobs were written in that order
+		var last uint64
+		var lastOff int64
+		n := 0
+		for ti := range dst.Tiles(WalkOptions{Distinct: true}) {
+			tid := c.Curve.TileID(zl, ti.X, ti.Y)
+			if n > 0 && tid <= last {
+				t.Fatalf("walk out of curve order at %d %d", ti.X, ti.Y)
+			}
+			dp, err := dst.getDataPointer(tid)
+			if err != nil {
+				t.Fatal(err)
+			} else if dp.offset <= lastOff {
+				t.Fatalf("blob for %d %d not written in curve order", ti.X, ti.Y)
+			}
+			last, lastOff = tid, dp.offset
+			n++
+		}
+		if n != 50 {
+			t.Fatalf("distinct walk returned %d tiles", n)
+		}
+		if r, err := dst.Verify(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		} else if !r.OK() {
+			t.Fatalf("bad verify after rewrite %+v", r)
+		}
+		if err = dst.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = src.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,202 @@
+package tilemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format identifies the encoding of the tiles in a tilemap
+type Format uint8
+
+const (
+	// FormatUnknown is used by files that do not record a format, their tiles are not checked
+	FormatUnknown Format = 0
+	FormatPNG     Format = 1
+	FormatJPEG    Format = 2
+	FormatWebP    Format = 3
+	FormatMVT     Format = 4 //mapbox vector tiles, raw or gzipped protobuf
+	FormatJSON    Format = 5 //JSON or GeoJSON, raw or gzipped
+
+	maxFormat = FormatJSON
+	sniffLen  = 64 //decompressed bytes sniffed from gzipped tiles
+)
+
+var (
+	ErrInvalidFormat  = errors.New("invalid tile format")
+	ErrFormatMismatch = errors.New("tile contents do not match the tilemap format")
+
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+	jpegMagic = []byte{0xff, 0xd8, 0xff}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatUnknown:
+		return `unknown`
+	case FormatPNG:
+		return `png`
+	case FormatJPEG:
+		return `jpeg`
+	case FormatWebP:
+		return `webp`
+	case FormatMVT:
+		return `mvt`
+	case FormatJSON:
+		return `json`
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(f))
+}
+
+// MimeType returns the MIME type served for the format
+func (f Format) MimeType() string {
+	switch f {
+	case FormatPNG:
+		return `image/png`
+	case FormatJPEG:
+		return `image/jpeg`
+	case FormatWebP:
+		return `image/webp`
+	case FormatMVT:
+		return `application/vnd.mapbox-vector-tile`
+	case FormatJSON:
+		return `application/json`
+	}
+	return `application/octet-stream`
+}
+
+// Extension returns the usual file extension for the format, including the dot
+func (f Format) Extension() string {
+	switch f {
+	case FormatPNG:
+		return `.png`
+	case FormatJPEG:
+		return `.jpg`
+	case FormatWebP:
+		return `.webp`
+	case FormatMVT:
+		return `.pbf`
+	case FormatJSON:
+		return `.json`
+	}
+	return ``
+}
+
+func (f Format) valid() bool {
+	return f <= maxFormat
+}
+
+// ParseFormat converts a format name or file extension, with or without the dot, to a Format
+func ParseFormat(s string) (f Format, err error) {
+	switch strings.ToLower(strings.TrimPrefix(s, `.`)) {
+	case `png`:
+		f = FormatPNG
+	case `jpg`, `jpeg`:
+		f = FormatJPEG
+	case `webp`:
+		f = FormatWebP
+	case `mvt`, `pbf`:
+		f = FormatMVT
+	case `json`, `geojson`:
+		f = FormatJSON
+	default:
+		err = ErrInvalidFormat
+	}
+	return
+}
+
+// FormatFromMime returns the format for a MIME type, parameters are ignored
+func FormatFromMime(mime string) Format {
+	mime, _, _ = strings.Cut(mime, `;`)
+	switch strings.ToLower(strings.TrimSpace(mime)) {
+	case `image/png`:
+		return FormatPNG
+	case `image/jpeg`, `image/jpg`:
+		return FormatJPEG
+	case `image/webp`:
+		return FormatWebP
+	case `application/vnd.mapbox-vector-tile`, `application/x-protobuf`:
+		return FormatMVT
+	case `application/json`, `application/geo+json`:
+		return FormatJSON
+	}
+	return FormatUnknown
+}
+
+// DetectFormat sniffs the format of a tile from its leading bytes.  Gzipped tiles are sniffed
+// by the start of their decompressed contents, so gzipped JSON is not mistaken for a vector tile.
+func DetectFormat(b []byte) Format {
+	if bytes.HasPrefix(b, gzipMagic) {
+		return detectGzip(b)
+	}
+	return detectRaw(b)
+}
+
+// detectGzip sniffs the first few decompressed bytes of a gzipped tile
+func detectGzip(b []byte) Format {
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return FormatUnknown
+	}
+	defer gr.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(gr, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return FormatUnknown
+	}
+	return detectRaw(head[:n])
+}
+
+func detectRaw(b []byte) Format {
+	switch {
+	case bytes.HasPrefix(b, pngMagic):
+		return FormatPNG
+	case bytes.HasPrefix(b, jpegMagic):
+		return FormatJPEG
+	case len(b) >= 12 && string(b[:4]) == `RIFF` && string(b[8:12]) == `WEBP`:
+		return FormatWebP
+	}
+	if t := bytes.TrimLeft(b, " \t\r\n"); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+		return FormatJSON
+	}
+	//vector tiles have no magic, they are a list of layers (field 3, length delimited)
+	if len(b) > 1 && b[0] == 0x1a {
+		return FormatMVT
+	}
+	return FormatUnknown
+}
+
+// Match returns true if b looks like a tile of the format, FormatUnknown matches anything
+func (f Format) Match(b []byte) bool {
+	return f == FormatUnknown || DetectFormat(b) == f
+}
+
+// Format returns the format of the tiles, files that do not record one fall back to their MIME type
+func (w *Tilemap) Format() Format {
+	w.RLock()
+	defer w.RUnlock()
+	if w.hdr.Format != FormatUnknown {
+		return w.hdr.Format
+	}
+	return FormatFromMime(w.hdr.MimeType)
+}
+
+// Format returns the tile format of a zoom level, FormatUnknown if the zoom is not present
+func (ts *Tileset) Format(zoom int) (f Format) {
+	if tm := ts.Tilemap(zoom); tm != nil {
+		f = tm.Format()
+	}
+	return
+}
+
+// MimeType returns the MIME type of the tiles at a zoom level, empty if the zoom is not present
+func (ts *Tileset) MimeType(zoom int) (mime string) {
+	if tm := ts.Tilemap(zoom); tm != nil {
+		mime = tm.Header().MimeType
+	}
+	return
+}
@@ -0,0 +1,124 @@
+package tilemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testPNG  = append(bytes.Clone(pngMagic), 0, 0, 0, 13, 'I', 'H', 'D', 'R')
+	testJPEG = []byte{0xff, 0xd8, 0xff, 0xe0, 0, 0x10, 'J', 'F', 'I', 'F'}
+	testWebP = []byte("RIFF\x24\x00\x00\x00WEBPVP8 ")
+	testMVT  = []byte{0x1a, 0x05, 0x0a, 0x03, 'r', 'o', 'a'}
+	testJSON = []byte(" {\"type\":\"FeatureCollection\"}")
+)
+
+func TestDetectFormat(t *testing.T) {
+	gz := func(b []byte) []byte {
+		var bb bytes.Buffer
+		gw := gzip.NewWriter(&bb)
+		gw.Write(b)
+		gw.Close()
+		return bb.Bytes()
+	}
+	for _, tt := range []struct {
+		buff []byte
+		f    Format
+	}{
+		{testPNG, FormatPNG},
+		{testJPEG, FormatJPEG},
+		{testWebP, FormatWebP},
+		{testMVT, FormatMVT},
+		{gz(testMVT), FormatMVT},
+		{gz(testJSON), FormatJSON}, //gzipped GeoJSON is not a vector tile
+		{gz([]byte(`hello`)), FormatUnknown},
+		{gzipMagic, FormatUnknown}, //truncated gzip header
+		{testJSON, FormatJSON},
+		{[]byte(`[1,2]`), FormatJSON},
+		{[]byte(`hello`), FormatUnknown},
+		{[]byte("RIFF\x24\x00\x00\x00WAVE"), FormatUnknown},
+		{nil, FormatUnknown},
+	} {
+		if f := DetectFormat(tt.buff); f != tt.f {
+			t.Fatalf("%q detected as %v not %v", tt.buff, f, tt.f)
+		}
+	}
+
+	for f := FormatPNG; f <= maxFormat; f++ {
+		if pf, err := ParseFormat(f.String()); err != nil || pf != f {
+			t.Fatalf("bad name round trip %v %v %v", f, pf, err)
+		} else if pf, err = ParseFormat(f.Extension()); err != nil || pf != f {
+			t.Fatalf("bad extension round trip %v %v %v", f, pf, err)
+		} else if mf := FormatFromMime(f.MimeType()); mf != f {
+			t.Fatalf("bad MIME round trip %v %v", f, mf)
+		}
+	}
+	if f, err := ParseFormat(`JPG`); err != nil || f != FormatJPEG {
+		t.Fatalf("bad jpg %v %v", f, err)
+	} else if _, err = ParseFormat(`gif`); err != ErrInvalidFormat {
+		t.Fatalf("Failed to catch bad format: %v", err)
+	} else if f = FormatFromMime(`application/json; charset=utf-8`); f != FormatJSON {
+		t.Fatalf("bad MIME with parameters %v", f)
+	}
+}
+
+func TestTilemapFormat(t *testing.T) {
+	pth := filepath.Join(tdir, `format`)
+	if _, err := NewTilemapConfig(pth, Config{Zoom: 2, Format: FormatWebP, MimeType: `image/png`}); err != ErrInvalidMimeType {
+		t.Fatalf("Failed to catch MIME type that does not match the format: %v", err)
+	}
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: 2, Format: FormatWebP})
+	if err != nil {
+		t.Fatal(err)
+	} else if hdr := wtr.Header(); hdr.Format != FormatWebP || hdr.MimeType != `image/webp` {
+		t.Fatalf("bad header %+v", hdr)
+	}
+	if err = wtr.Add(0, 0, testWebP); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(1, 0, testPNG); err != ErrFormatMismatch {
+		t.Fatalf("Failed to reject a png in a webp tilemap: %v", err)
+	} else if err = wtr.AddBatch([]Tile{{X: 1, Y: 1, Data: testWebP}, {X: 2, Y: 2, Data: testJSON}}); err != ErrFormatMismatch {
+		t.Fatalf("Failed to reject a mismatched batch: %v", err)
+	} else if wtr.Has(1, 1) {
+		t.Fatal("rejected batch was partially written")
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the format survives a reopen and a rewrite
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if rdr.Format() != FormatWebP {
+		t.Fatalf("bad format on reopen %v", rdr.Format())
+	}
+	out := filepath.Join(tdir, `formatrewrite`)
+	if err = Rewrite(rdr, out, Config{Curve: CurveHilbert}); err != nil {
+		t.Fatal(err)
+	} else if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	} else if hdr, err := ReadHeader(out); err != nil {
+		t.Fatal(err)
+	} else if hdr.Format != FormatWebP || hdr.MimeType != `image/webp` {
+		t.Fatalf("format lost in rewrite %+v", hdr)
+	}
+
+	//files without a recorded format take it from the MIME type and accept anything
+	ts, err := OpenTilesetConfig(filepath.Join(tdir, `formatset`), Config{MimeType: `image/jpeg`})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = ts.Add(1, 0, 0, []byte(`not really a jpeg`)); err != nil {
+		t.Fatal(err)
+	} else if f := ts.Format(1); f != FormatJPEG {
+		t.Fatalf("bad tileset format %v", f)
+	} else if mime := ts.MimeType(1); mime != `image/jpeg` {
+		t.Fatalf("bad tileset MIME type %q", mime)
+	} else if f = ts.Format(2); f != FormatUnknown {
+		t.Fatalf("missing zoom has format %v", f)
+	} else if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
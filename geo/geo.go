@@ -0,0 +1,178 @@
+// Package geo holds the Web Mercator (EPSG:3857) math used to move between latitude and
+// longitude, XYZ tile coordinates, and projected meters.
+package geo
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	EarthRadius  = 6378137.0             //WGS84 semi-major axis in meters
+	OriginShift  = math.Pi * EarthRadius //meters from the origin to the edge of the map
+	MaxLatitude  = 85.05112877980659     //the map is square between these latitudes
+	MinLatitude  = -MaxLatitude
+	MaxLongitude = 180.0
+	MinLongitude = -180.0
+	MaxZoom      = 30 //tile coordinates no longer fit in 32 bits above this
+
+	edgeEpsilon = 1e-9 //tile units, absorbs float error on tile edges
+)
+
+var (
+	ErrInvalidBBox = errors.New("invalid bounding box")
+	ErrInvalidZoom = errors.New("invalid zoom")
+)
+
+// BBox is a box in degrees.  Boxes that cross the antimeridian have to be split in two.
+type BBox struct {
+	West, South, East, North float64
+}
+
+// Valid returns true if the box is within the range of latitude and longitude and is not inverted
+func (b BBox) Valid() bool {
+	return b.West <= b.East && b.South <= b.North &&
+		b.West >= MinLongitude && b.East <= MaxLongitude && b.South >= -90 && b.North <= 90
+}
+
+// Contains returns true if the point is within the box, edges included
+func (b BBox) Contains(lat, lon float64) bool {
+	return lat >= b.South && lat <= b.North && lon >= b.West && lon <= b.East
+}
+
+// Intersects returns true if the boxes overlap
+func (b BBox) Intersects(o BBox) bool {
+	return b.West <= o.East && o.West <= b.East && b.South <= o.North && o.South <= b.North
+}
+
+// Mercator projects the box into EPSG:3857 meters
+func (b BBox) Mercator() MercatorBounds {
+	minX, minY := LatLonToMeters(b.South, b.West)
+	maxX, maxY := LatLonToMeters(b.North, b.East)
+	return MercatorBounds{MinX: minX, MinY: minY, MaxX: maxX, MaxY: maxY}
+}
+
+// MercatorBounds is a box in EPSG:3857 meters
+type MercatorBounds struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// BBox converts the bounds back to degrees
+func (m MercatorBounds) BBox() BBox {
+	south, west := MetersToLatLon(m.MinX, m.MinY)
+	north, east := MetersToLatLon(m.MaxX, m.MaxY)
+	return BBox{West: west, South: south, East: east, North: north}
+}
+
+// TileRange is an inclusive range of tiles at a zoom level
+type TileRange struct {
+	Zoom       int
+	MinX, MinY int
+	MaxX, MaxY int
+}
+
+// Count returns the number of tiles in the range
+func (r TileRange) Count() int64 {
+	return int64(r.MaxX-r.MinX+1) * int64(r.MaxY-r.MinY+1)
+}
+
+// Contains returns true if the tile is within the range
+func (r TileRange) Contains(x, y int) bool {
+	return x >= r.MinX && x <= r.MaxX && y >= r.MinY && y <= r.MaxY
+}
+
+// LatLonToMeters projects a point into EPSG:3857, latitudes are clamped to the square map
+func LatLonToMeters(lat, lon float64) (mx, my float64) {
+	lat = clamp(lat, MinLatitude, MaxLatitude)
+	mx = lon * OriginShift / 180.0
+	my = math.Log(math.Tan((90+lat)*math.Pi/360.0)) * EarthRadius
+	return
+}
+
+// MetersToLatLon converts an EPSG:3857 point back to degrees
+func MetersToLatLon(mx, my float64) (lat, lon float64) {
+	lon = mx / OriginShift * 180.0
+	lat = 180.0/math.Pi*2*math.Atan(math.Exp(my/EarthRadius)) - 90
+	return
+}
+
+// LatLonToTileFrac returns the fractional tile coordinates of a point, the integer parts are
+// the tile and the fractions are the position within it.  Latitudes are clamped to the square map.
+func LatLonToTileFrac(zoom int, lat, lon float64) (fx, fy float64) {
+	n := float64(uint64(1) << uint(zoom))
+	lat = clamp(lat, MinLatitude, MaxLatitude)
+	rad := lat * math.Pi / 180.0
+	fx = (lon + 180.0) / 360.0 * n
+	fy = (1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * n
+	return
+}
+
+// TileFracToLatLon is the inverse of LatLonToTileFrac
+func TileFracToLatLon(zoom int, fx, fy float64) (lat, lon float64) {
+	n := float64(uint64(1) << uint(zoom))
+	lon = fx/n*360.0 - 180.0
+	lat = math.Atan(math.Sinh(math.Pi*(1-2*fy/n))) * 180.0 / math.Pi
+	return
+}
+
+// LatLonToTile returns the tile holding a point, points off the edge of the map land in the edge tile
+func LatLonToTile(zoom int, lat, lon float64) (x, y int) {
+	fx, fy := LatLonToTileFrac(zoom, lat, lon)
+	edge := float64((int64(1) << uint(zoom)) - 1)
+	x = int(clamp(math.Floor(fx), 0, edge))
+	y = int(clamp(math.Floor(fy), 0, edge))
+	return
+}
+
+// TileToLatLon returns the north west corner of a tile
+func TileToLatLon(zoom, x, y int) (lat, lon float64) {
+	return TileFracToLatLon(zoom, float64(x), float64(y))
+}
+
+// TileBBox returns the bounds of a tile in degrees
+func TileBBox(zoom, x, y int) BBox {
+	north, west := TileToLatLon(zoom, x, y)
+	south, east := TileToLatLon(zoom, x+1, y+1)
+	return BBox{West: west, South: south, East: east, North: north}
+}
+
+// TileMercator returns the bounds of a tile in EPSG:3857 meters
+func TileMercator(zoom, x, y int) MercatorBounds {
+	size := 2 * OriginShift / float64(uint64(1)<<uint(zoom))
+	return MercatorBounds{
+		MinX: float64(x)*size - OriginShift,
+		MinY: OriginShift - float64(y+1)*size,
+		MaxX: float64(x+1)*size - OriginShift,
+		MaxY: OriginShift - float64(y)*size,
+	}
+}
+
+// BBoxTiles returns the range of tiles covering a box at a zoom level.  Tiles that only touch
+// the box along an edge are not included, so the box of a single tile covers just that tile.
+func BBoxTiles(zoom int, b BBox) (r TileRange, err error) {
+	if zoom < 0 || zoom > MaxZoom {
+		err = ErrInvalidZoom
+		return
+	} else if !b.Valid() {
+		err = ErrInvalidBBox
+		return
+	}
+	edge := float64((int64(1) << uint(zoom)) - 1)
+	minFx, minFy := LatLonToTileFrac(zoom, b.North, b.West)
+	maxFx, maxFy := LatLonToTileFrac(zoom, b.South, b.East)
+	r = TileRange{
+		Zoom: zoom,
+		MinX: int(clamp(math.Floor(minFx+edgeEpsilon), 0, edge)),
+		MinY: int(clamp(math.Floor(minFy+edgeEpsilon), 0, edge)),
+		MaxX: int(clamp(math.Ceil(maxFx-edgeEpsilon)-1, 0, edge)),
+		MaxY: int(clamp(math.Ceil(maxFy-edgeEpsilon)-1, 0, edge)),
+	}
+	//a box narrower than a tile edge still lands in one tile
+	r.MaxX = max(r.MaxX, r.MinX)
+	r.MaxY = max(r.MaxY, r.MinY)
+	return
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Min(math.Max(v, lo), hi)
+}
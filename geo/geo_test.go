@@ -0,0 +1,170 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+const (
+	degEpsilon   = 1e-9
+	meterEpsilon = 1e-6
+)
+
+func near(a, b, eps float64) bool {
+	return math.Abs(a-b) <= eps
+}
+
+func TestLatLonToTile(t *testing.T) {
+	tests := []struct {
+		zoom     int
+		lat, lon float64
+		x, y     int
+	}{
+		{0, 0, 0, 0, 0},
+		{1, 10, -10, 0, 0},
+		{1, -10, 10, 1, 1},
+		{10, 52.52, 13.405, 550, 335},        //Berlin
+		{18, 40.7128, -74.006, 77182, 98561}, //New York
+		{3, -33.8688, 151.2093, 7, 4},        //Sydney
+		//off the edges of the map land in the edge tiles
+		{4, 89.9, -180, 0, 0},
+		{4, -89.9, 180, 15, 15},
+		{4, 0, 200, 15, 8},
+	}
+	for _, tt := range tests {
+		if x, y := LatLonToTile(tt.zoom, tt.lat, tt.lon); x != tt.x || y != tt.y {
+			t.Fatalf("%d %f %f gave %d %d != %d %d", tt.zoom, tt.lat, tt.lon, x, y, tt.x, tt.y)
+		}
+	}
+	fx, fy := LatLonToTileFrac(10, 52.52, 13.405)
+	if !near(fx, 550.1297777777778, degEpsilon) || !near(fy, 335.8260846675106, degEpsilon) {
+		t.Fatalf("bad fractional tile %f %f", fx, fy)
+	}
+	if lat, lon := TileFracToLatLon(10, fx, fy); !near(lat, 52.52, degEpsilon) || !near(lon, 13.405, degEpsilon) {
+		t.Fatalf("bad fractional round trip %f %f", lat, lon)
+	}
+}
+
+func TestTileBBox(t *testing.T) {
+	world := TileBBox(0, 0, 0)
+	if !near(world.West, -180, degEpsilon) || !near(world.East, 180, degEpsilon) ||
+		!near(world.North, MaxLatitude, degEpsilon) || !near(world.South, MinLatitude, degEpsilon) {
+		t.Fatalf("bad world box %+v", world)
+	}
+	for zoom := 1; zoom <= 12; zoom += 3 {
+		dim := 1 << uint(zoom)
+		for _, xy := range [][2]int{{0, 0}, {dim / 2, dim / 3}, {dim - 1, dim - 1}} {
+			x, y := xy[0], xy[1]
+			b := TileBBox(zoom, x, y)
+			if !b.Valid() {
+				t.Fatalf("invalid tile box %+v", b)
+			}
+			//neighbours share edges
+			if r := TileBBox(zoom, x+1, y); !near(r.West, b.East, degEpsilon) {
+				t.Fatalf("east neighbour does not share an edge %+v %+v", b, r)
+			} else if d := TileBBox(zoom, x, y+1); !near(d.North, b.South, degEpsilon) {
+				t.Fatalf("south neighbour does not share an edge %+v %+v", b, d)
+			}
+			//the center of the box is in the tile and the box covers exactly the tile
+			clat, clon := TileFracToLatLon(zoom, float64(x)+0.5, float64(y)+0.5)
+			if tx, ty := LatLonToTile(zoom, clat, clon); tx != x || ty != y {
+				t.Fatalf("center of %d %d is in %d %d", x, y, tx, ty)
+			} else if !b.Contains(clat, clon) {
+				t.Fatalf("box %+v does not contain its center", b)
+			}
+			if r, err := BBoxTiles(zoom, b); err != nil {
+				t.Fatal(err)
+			} else if r.Count() != 1 || !r.Contains(x, y) {
+				t.Fatalf("box of %d/%d/%d covers %+v", zoom, x, y, r)
+			}
+			//the north west corner is the tile, not the neighbours that share it
+			if lat, lon := TileToLatLon(zoom, x, y); !near(lat, b.North, degEpsilon) || !near(lon, b.West, degEpsilon) {
+				t.Fatalf("bad corner %f %f", lat, lon)
+			}
+		}
+	}
+}
+
+func TestBBoxTiles(t *testing.T) {
+	world := BBox{West: -180, South: -90, East: 180, North: 90}
+	for zoom := 0; zoom <= 10; zoom++ {
+		r, err := BBoxTiles(zoom, world)
+		if err != nil {
+			t.Fatal(err)
+		} else if r.MinX != 0 || r.MinY != 0 || r.MaxX != (1<<uint(zoom))-1 || r.MaxY != r.MaxX || r.Zoom != zoom {
+			t.Fatalf("bad world range %+v", r)
+		} else if r.Count() != int64(1)<<(2*uint(zoom)) {
+			t.Fatalf("bad world count %d", r.Count())
+		}
+	}
+	//a box spanning the corner of four tiles
+	a, b := TileBBox(5, 10, 10), TileBBox(5, 11, 11)
+	corner := BBox{
+		West:  (a.West + a.East) / 2,
+		North: (a.North + a.South) / 2,
+		East:  (b.West + b.East) / 2,
+		South: (b.North + b.South) / 2,
+	}
+	if r, err := BBoxTiles(5, corner); err != nil {
+		t.Fatal(err)
+	} else if r != (TileRange{Zoom: 5, MinX: 10, MinY: 10, MaxX: 11, MaxY: 11}) {
+		t.Fatalf("bad corner range %+v", r)
+	}
+	//a point is a single tile
+	if r, err := BBoxTiles(8, BBox{West: 13.405, East: 13.405, South: 52.52, North: 52.52}); err != nil {
+		t.Fatal(err)
+	} else if x, y := LatLonToTile(8, 52.52, 13.405); r.Count() != 1 || !r.Contains(x, y) {
+		t.Fatalf("bad point range %+v", r)
+	}
+	for _, bad := range []BBox{
+		{West: 10, East: -10, South: 0, North: 1}, //crosses the antimeridian
+		{West: 0, East: 1, South: 10, North: -10},
+		{West: -181, East: 0, South: 0, North: 1},
+	} {
+		if _, err := BBoxTiles(3, bad); err != ErrInvalidBBox {
+			t.Fatalf("Failed to catch bad box %+v: %v", bad, err)
+		}
+	}
+	if _, err := BBoxTiles(MaxZoom+1, world); err != ErrInvalidZoom {
+		t.Fatalf("Failed to catch bad zoom: %v", err)
+	}
+}
+
+func TestMeters(t *testing.T) {
+	if mx, my := LatLonToMeters(0, 0); !near(mx, 0, meterEpsilon) || !near(my, 0, meterEpsilon) {
+		t.Fatalf("bad origin %f %f", mx, my)
+	}
+	if mx, my := LatLonToMeters(MaxLatitude, 180); !near(mx, OriginShift, meterEpsilon) || !near(my, OriginShift, 1e-3) {
+		t.Fatalf("bad corner %f %f", mx, my)
+	}
+	if !near(OriginShift, 20037508.342789244, meterEpsilon) {
+		t.Fatalf("bad origin shift %f", OriginShift)
+	}
+	//reference value for Berlin from EPSG:3857
+	mx, my := LatLonToMeters(52.52, 13.405)
+	if !near(mx, 1492237.7740838323, 1e-3) || !near(my, 6894699.801282056, 1e-3) {
+		t.Fatalf("bad projection %f %f", mx, my)
+	}
+	if lat, lon := MetersToLatLon(mx, my); !near(lat, 52.52, degEpsilon) || !near(lon, 13.405, degEpsilon) {
+		t.Fatalf("bad inverse projection %f %f", lat, lon)
+	}
+	//tile bounds agree in both units
+	for _, tc := range [][3]int{{0, 0, 0}, {4, 3, 12}, {12, 1205, 1539}} {
+		m := TileMercator(tc[0], tc[1], tc[2])
+		b := TileBBox(tc[0], tc[1], tc[2])
+		pm := b.Mercator()
+		if !near(m.MinX, pm.MinX, 1e-3) || !near(m.MinY, pm.MinY, 1e-3) ||
+			!near(m.MaxX, pm.MaxX, 1e-3) || !near(m.MaxY, pm.MaxY, 1e-3) {
+			t.Fatalf("tile %v bounds disagree %+v %+v", tc, m, pm)
+		}
+		if rb := m.BBox(); !near(rb.West, b.West, degEpsilon) || !near(rb.North, b.North, degEpsilon) ||
+			!near(rb.East, b.East, degEpsilon) || !near(rb.South, b.South, degEpsilon) {
+			t.Fatalf("tile %v bounds do not round trip %+v %+v", tc, rb, b)
+		}
+	}
+	if !TileBBox(2, 1, 1).Intersects(TileBBox(2, 2, 2)) {
+		t.Fatal("tiles sharing a corner do not intersect")
+	} else if TileBBox(2, 0, 0).Intersects(TileBBox(2, 2, 2)) {
+		t.Fatal("distant tiles intersect")
+	}
+}
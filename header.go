@@ -0,0 +1,421 @@
+package tilemap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	headerMagic     = "GWTILMAP"
+	headerVersion   = 2    //newest header version we can read
+	headerVersionV1 = 1    //written when a file uses none of the version 2 features, see minVersion
+	headerSize      = 4096 //a single page so the datapointer region stays page aligned
+
+	hdrSeqOffset  = 64 //index sequence, odd while a writer is changing the index
+	hdrMimeOffset = 128
+	hdrMetaOffset = 256
+	maxMimeLen    = hdrMetaOffset - hdrMimeOffset
+	maxMetaLen    = headerSize - hdrMetaOffset
+
+	hdrFlagDirty     = 0x1
+	hdrFlagChecksums = 0x2
+
+	DefaultMimeType = `image/png`
+	DefaultTileSize = 256
+
+	// AnyZoom tells NewTilemapConfig to take the zoom from the file header
+	AnyZoom = -1
+)
+
+var (
+	ErrInvalidHeader      = errors.New("invalid tilemap header, file may be corrupt")
+	ErrUnsupportedVersion = errors.New("unsupported tilemap file version")
+	ErrZoomMismatch       = errors.New("requested zoom does not match tilemap header")
+	ErrNoHeader           = errors.New("tilemap file does not have a header")
+	ErrInvalidMimeType    = errors.New("invalid tile mime type")
+	ErrMetadataTooLarge   = errors.New("tilemap metadata is too large")
+)
+
+// Metadata is the free form block stored in the file header
+type Metadata struct {
+	Name        string            `json:",omitempty"`
+	Attribution string            `json:",omitempty"`
+	Bounds      [4]float64        `json:",omitempty"` //west, south, east, north
+	Extra       map[string]string `json:",omitempty"`
+}
+
+// Header describes the contents of a tilemap file.
+// A Version of zero indicates a legacy headerless file.
+type Header struct {
+	Version     uint16
+	Zoom        int
+	TileSize    int
+	MimeType    string
+	Created     time.Time
+	Metadata    Metadata
+	Dirty       bool        //set while a writer has the file open
+	Index       IndexType   //layout of the tile index
+	Checksums   bool        //each blob is followed by a CRC32C of its contents
+	Compression Compression //how blobs are stored
+	Curve       Curve       //how tile coordinates map to tile ids
+	Region      Region      //window of tiles covered by the index, requires version 2
+	Format      Format      //tile format checked on Add, FormatUnknown if not recorded
+
+	idxOffset int64 //location of the sparse index table
+	idxSlots  int64 //number of entries in the sparse index table
+}
+
+// header layout, all values are little endian
+// [0:8]   magic
+// [8:10]  version
+// [10]    zoom
+// [11]    flags
+// [12:14] tile size in pixels
+// [14:16] mime type length
+// [16:24] creation time in unix nanoseconds
+// [24:28] metadata length
+// [28]    index type
+// [29]    compression
+// [30]    tile id curve
+// [31]    tile format
+// [32:40] sparse index offset
+// [40:48] sparse index slots
+// [48:52] region origin x
+// [52:56] region origin y
+// [56:60] region width
+// [60:64] region height
+// [64:68] index sequence, owned by the writer and never touched by Encode
+// [68:128] reserved
+// [128:256] mime type
+// [256:4096] JSON encoded metadata
+
+// Legacy returns true if the header describes a legacy headerless file
+func (h *Header) Legacy() bool {
+	return h.Version == 0
+}
+
+// size returns the number of bytes the header occupies at the start of the file
+func (h *Header) size() int64 {
+	if h.Legacy() {
+		return 0
+	}
+	return headerSize
+}
+
+// indexOffset returns the location of the tile index within the file
+func (h *Header) indexOffset() int64 {
+	if h.Index == IndexSparse {
+		return h.idxOffset
+	}
+	return h.size()
+}
+
+// indexSize returns the number of bytes occupied by the tile index
+func (h *Header) indexSize() int64 {
+	if h.Index == IndexSparse {
+		return h.idxSlots * sparseEntrySize
+	}
+	return h.tileCount() * dpsize
+}
+
+// tileCount returns the number of tile ids covered by the index
+func (h *Header) tileCount() int64 {
+	return h.Region.tileCount(h.Curve, h.Zoom)
+}
+
+// dataStart returns the offset of the blob region.  Sparse index tables live among the
+// blobs, so sparse files start their blob region right after the header.
+func (h *Header) dataStart() int64 {
+	if h.Index == IndexSparse {
+		return h.size()
+	}
+	return h.size() + h.indexSize()
+}
+
+// setIndex selects the index layout, the dense index is only used when it is practical
+func (h *Header) setIndex(t IndexType) {
+	if t == IndexSparse || h.tileCount() > tileCount(maxDimension) {
+		h.Index = IndexSparse
+		h.idxOffset = headerSize
+		h.idxSlots = sparseInitSlots
+	} else {
+		h.Index = IndexDense
+		h.idxOffset = 0
+		h.idxSlots = 0
+	}
+}
+
+// validIndex checks that the index layout is consistent with the zoom level
+func (h *Header) validIndex() (err error) {
+	switch h.Index {
+	case IndexDense:
+		if h.tileCount() > tileCount(maxDimension) {
+			err = ErrInvalidDimension
+		}
+	case IndexSparse:
+		if h.idxOffset < headerSize || h.idxSlots <= 0 || h.idxSlots&(h.idxSlots-1) != 0 {
+			err = ErrInvalidHeader
+		}
+	default:
+		err = ErrUnsupportedVersion
+	}
+	return
+}
+
+// minVersion returns the oldest header version that can describe the file, older readers
+// would misread a sparse index, checksum trailers, compressed blobs, a tile id curve, or a region
+func (h *Header) minVersion() uint16 {
+	if h.Index != IndexDense || h.Checksums || h.Compression != CompressNone || h.Curve != CurveRowMajor || h.Region.Set() {
+		return headerVersion
+	}
+	return headerVersionV1
+}
+
+func (h *Header) Encode(b []byte) (err error) {
+	var meta []byte
+	if len(b) < headerSize {
+		err = ErrInvalidBufferSize
+		return
+	} else if len(h.MimeType) == 0 || len(h.MimeType) > maxMimeLen {
+		err = ErrInvalidMimeType
+		return
+	} else if h.Zoom < 0 || h.Zoom > MaxZoom {
+		err = ErrInvalidDimension
+		return
+	} else if !h.Region.valid(h.Zoom) {
+		err = ErrInvalidRegion
+		return
+	} else if err = h.validIndex(); err != nil {
+		return
+	} else if !h.Compression.valid() {
+		err = ErrInvalidCompression
+		return
+	} else if !h.Curve.valid() {
+		err = ErrInvalidCurve
+		return
+	} else if !h.Format.valid() {
+		err = ErrInvalidFormat
+		return
+	} else if h.Version < h.minVersion() {
+		err = ErrUnsupportedVersion
+		return
+	}
+	if meta, err = json.Marshal(h.Metadata); err != nil {
+		return
+	} else if len(meta) > maxMetaLen {
+		err = ErrMetadataTooLarge
+		return
+	}
+	b = b[:headerSize]
+	for i := range b {
+		if i < hdrSeqOffset || i >= hdrSeqOffset+4 {
+			b[i] = 0
+		}
+	}
+	copy(b, headerMagic)
+	binary.LittleEndian.PutUint16(b[8:], h.Version)
+	b[10] = uint8(h.Zoom)
+	if h.Dirty {
+		b[11] |= hdrFlagDirty
+	}
+	if h.Checksums {
+		b[11] |= hdrFlagChecksums
+	}
+	binary.LittleEndian.PutUint16(b[12:], uint16(h.TileSize))
+	binary.LittleEndian.PutUint16(b[14:], uint16(len(h.MimeType)))
+	binary.LittleEndian.PutUint64(b[16:], uint64(h.Created.UnixNano()))
+	binary.LittleEndian.PutUint32(b[24:], uint32(len(meta)))
+	b[28] = uint8(h.Index)
+	b[29] = uint8(h.Compression)
+	b[30] = uint8(h.Curve)
+	b[31] = uint8(h.Format)
+	binary.LittleEndian.PutUint64(b[32:], uint64(h.idxOffset))
+	binary.LittleEndian.PutUint64(b[40:], uint64(h.idxSlots))
+	binary.LittleEndian.PutUint32(b[48:], uint32(h.Region.X))
+	binary.LittleEndian.PutUint32(b[52:], uint32(h.Region.Y))
+	binary.LittleEndian.PutUint32(b[56:], uint32(h.Region.Width))
+	binary.LittleEndian.PutUint32(b[60:], uint32(h.Region.Height))
+	copy(b[hdrMimeOffset:], h.MimeType)
+	copy(b[hdrMetaOffset:], meta)
+	return
+}
+
+func (h *Header) Decode(b []byte) (err error) {
+	if len(b) < headerSize {
+		err = ErrInvalidBufferSize
+		return
+	} else if !hasMagic(b) {
+		err = ErrInvalidHeader
+		return
+	}
+	var nh Header
+	nh.Version = binary.LittleEndian.Uint16(b[8:])
+	if nh.Version == 0 {
+		err = ErrInvalidHeader
+		return
+	} else if nh.Version > headerVersion {
+		err = ErrUnsupportedVersion
+		return
+	}
+	nh.Zoom = int(b[10])
+	nh.Dirty = b[11]&hdrFlagDirty != 0
+	nh.Checksums = b[11]&hdrFlagChecksums != 0
+	nh.TileSize = int(binary.LittleEndian.Uint16(b[12:]))
+	mimeLen := int(binary.LittleEndian.Uint16(b[14:]))
+	nh.Created = time.Unix(0, int64(binary.LittleEndian.Uint64(b[16:])))
+	metaLen := int(binary.LittleEndian.Uint32(b[24:]))
+	nh.Index = IndexType(b[28])
+	nh.Compression = Compression(b[29])
+	nh.Curve = Curve(b[30])
+	nh.Format = Format(b[31])
+	nh.idxOffset = int64(binary.LittleEndian.Uint64(b[32:]))
+	nh.idxSlots = int64(binary.LittleEndian.Uint64(b[40:]))
+	nh.Region = Region{
+		X:      int(binary.LittleEndian.Uint32(b[48:])),
+		Y:      int(binary.LittleEndian.Uint32(b[52:])),
+		Width:  int(binary.LittleEndian.Uint32(b[56:])),
+		Height: int(binary.LittleEndian.Uint32(b[60:])),
+	}
+	if nh.Zoom > MaxZoom {
+		err = ErrInvalidDimension
+		return
+	} else if !nh.Region.valid(nh.Zoom) {
+		err = ErrInvalidHeader
+		return
+	} else if err = nh.validIndex(); err != nil {
+		return
+	} else if !nh.Compression.valid() || !nh.Curve.valid() || !nh.Format.valid() {
+		err = ErrUnsupportedVersion
+		return
+	} else if nh.Version < nh.minVersion() {
+		err = ErrInvalidHeader
+		return
+	} else if mimeLen == 0 || mimeLen > maxMimeLen {
+		err = ErrInvalidMimeType
+		return
+	} else if metaLen > maxMetaLen {
+		err = ErrMetadataTooLarge
+		return
+	}
+	nh.MimeType = string(b[hdrMimeOffset : hdrMimeOffset+mimeLen])
+	if metaLen > 0 {
+		if err = json.Unmarshal(b[hdrMetaOffset:hdrMetaOffset+metaLen], &nh.Metadata); err != nil {
+			return
+		}
+	}
+	*h = nh
+	return
+}
+
+func hasMagic(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(headerMagic))
+}
+
+// ReadHeader pulls the header from a tilemap file without opening the tile map
+func ReadHeader(pth string) (h Header, err error) {
+	var fio *os.File
+	if fio, err = os.Open(pth); err != nil {
+		return
+	}
+	defer fio.Close()
+	buff := make([]byte, headerSize)
+	if _, err = fio.ReadAt(buff, 0); err != nil {
+		if !hasMagic(buff) {
+			err = ErrNoHeader
+		}
+		return
+	} else if !hasMagic(buff) {
+		err = ErrNoHeader
+		return
+	}
+	err = h.Decode(buff)
+	return
+}
+
+// loadHeader reads the header of a tilemap, writing a new one when the tilemap is empty.
+// Non-empty tilemaps without a header are treated as legacy files.
+func loadHeader(st storage, size int64, c Config) (h Header, err error) {
+	if size == 0 {
+		if c.ReadOnly {
+			err = errors.New("File map is undersized")
+			return
+		} else if c.Zoom == AnyZoom {
+			err = ErrInvalidDimension
+			return
+		} else if !c.Region.valid(c.Zoom) {
+			err = ErrInvalidRegion
+			return
+		} else if !c.Format.valid() {
+			err = ErrInvalidFormat
+			return
+		}
+		h = Header{
+			Zoom:        c.Zoom,
+			TileSize:    c.TileSize,
+			MimeType:    c.MimeType,
+			Created:     time.Now().UTC(),
+			Metadata:    c.Metadata,
+			Checksums:   c.Checksums,
+			Compression: c.Compression,
+			Curve:       c.Curve,
+			Region:      c.Region,
+			Format:      c.Format,
+		}
+		h.setIndex(c.Index)
+		h.Version = h.minVersion()
+		if h.TileSize == 0 {
+			h.TileSize = DefaultTileSize
+		}
+		if h.MimeType == `` && h.Format != FormatUnknown {
+			h.MimeType = h.Format.MimeType()
+		} else if h.MimeType == `` {
+			h.MimeType = DefaultMimeType
+		} else if h.Format != FormatUnknown && FormatFromMime(h.MimeType) != h.Format {
+			err = ErrInvalidMimeType
+			return
+		}
+		buff := make([]byte, headerSize)
+		if err = h.Encode(buff); err != nil {
+			return
+		}
+		var n int
+		if n, err = st.WriteAt(buff, 0); err != nil {
+			return
+		} else if n != len(buff) {
+			err = ErrPartialWrite
+		}
+		return
+	}
+
+	if size > headerSize {
+		size = headerSize
+	}
+	buff := make([]byte, size)
+	n, _ := st.ReadAt(buff, 0)
+	if !hasMagic(buff[:n]) {
+		//legacy file, we have to be told the zoom and the index is always dense
+		if c.Zoom == AnyZoom {
+			err = ErrNoHeader
+			return
+		} else if c.Zoom > maxDimension {
+			err = ErrInvalidDimension
+			return
+		}
+		h = Header{
+			Zoom:     c.Zoom,
+			TileSize: DefaultTileSize,
+			MimeType: DefaultMimeType,
+		}
+		return
+	}
+	if err = h.Decode(buff[:n]); err != nil {
+		return
+	} else if c.Zoom != AnyZoom && c.Zoom != h.Zoom {
+		err = ErrZoomMismatch
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package tilemap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeaderEncodeDecode(t *testing.T) {
+	h := Header{
+		Version:  headerVersion,
+		Zoom:     7,
+		TileSize: 512,
+		MimeType: `image/jpeg`,
+		Created:  time.Unix(0, 1234567890),
+		Metadata: Metadata{
+			Name:        `test`,
+			Attribution: `somebody`,
+			Bounds:      [4]float64{-10, -20, 30, 40},
+			Extra:       map[string]string{`foo`: `bar`},
+		},
+	}
+	buff := make([]byte, headerSize)
+	if err := h.Encode(buff[:100]); err == nil {
+		t.Fatal("Failed to catch small buffer")
+	} else if err = h.Encode(buff); err != nil {
+		t.Fatal(err)
+	}
+	var h2 Header
+	if err := h2.Decode(buff); err != nil {
+		t.Fatal(err)
+	}
+	if h2.Version != h.Version || h2.Zoom != h.Zoom || h2.TileSize != h.TileSize ||
+		h2.MimeType != h.MimeType || !h2.Created.Equal(h.Created) ||
+		h2.Metadata.Name != h.Metadata.Name || h2.Metadata.Attribution != h.Metadata.Attribution ||
+		h2.Metadata.Bounds != h.Metadata.Bounds || h2.Metadata.Extra[`foo`] != `bar` {
+		t.Fatalf("Invalid decode: %+v != %+v", h2, h)
+	}
+
+	//bad magic and future versions must fail
+	buff[0] = 'X'
+	if err := h2.Decode(buff); err != ErrInvalidHeader {
+		t.Fatalf("Failed to catch bad magic: %v", err)
+	}
+	h.Version = headerVersion + 1
+	if err := h.Encode(buff); err != nil {
+		t.Fatal(err)
+	} else if err = h2.Decode(buff); err != ErrUnsupportedVersion {
+		t.Fatalf("Failed to catch bad version: %v", err)
+	}
+}
+
+func TestHeaderVersion(t *testing.T) {
+	//a version 1 reader refuses anything newer than version 1
+	v1Reads := func(pth string) bool {
+		buff, err := os.ReadFile(pth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return binary.LittleEndian.Uint16(buff[8:]) <= headerVersionV1
+	}
+	for i, c := range []Config{
+		{Zoom: 3, Index: IndexSparse},
+		{Zoom: 3, Checksums: true},
+		{Zoom: 3, Compression: CompressGzip},
+		{Zoom: 3, Curve: CurveMorton},
+		{Zoom: 3, Region: Region{Width: 2, Height: 2}},
+		{Zoom: 17},
+	} {
+		pth := filepath.Join(tdir, fmt.Sprintf("version%d", i))
+		wtr, err := NewTilemapConfig(pth, c)
+		if err != nil {
+			t.Fatal(err)
+		} else if hdr := wtr.Header(); hdr.Version != headerVersion {
+			t.Fatalf("%+v wrote version %d", c, hdr.Version)
+		} else if err = wtr.Close(); err != nil {
+			t.Fatal(err)
+		} else if v1Reads(pth) {
+			t.Fatalf("version 1 reader would open %+v", c)
+		}
+	}
+	pth := filepath.Join(tdir, `versionplain`)
+	if wtr, err := NewTilemapConfig(pth, Config{Zoom: 3}); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	} else if !v1Reads(pth) {
+		t.Fatal("plain file is not readable by version 1 readers")
+	}
+
+	//version 1 headers cannot carry version 2 features
+	h := Header{Version: headerVersion, Zoom: 3, MimeType: DefaultMimeType}
+	h.setIndex(IndexSparse)
+	buff := make([]byte, headerSize)
+	if err := h.Encode(buff); err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(buff[8:], headerVersionV1)
+	if err := h.Decode(buff); err != ErrInvalidHeader {
+		t.Fatalf("Failed to catch version 1 sparse header: %v", err)
+	}
+	h.Version = headerVersionV1
+	if err := h.Encode(buff); err != ErrUnsupportedVersion {
+		t.Fatalf("Failed to refuse version 1 sparse header: %v", err)
+	}
+}
+
+func TestTilemapHeader(t *testing.T) {
+	pth := filepath.Join(tdir, `header`)
+	md := Metadata{Name: `header test`, Attribution: `OSM`}
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: 3, MimeType: `image/webp`, Metadata: md})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff := randTile(basicBuff)
+	if err = wtr.Add(1, 2, buff); err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewTilemap(pth, 4, true); err != ErrZoomMismatch {
+		t.Fatalf("Failed to catch zoom mismatch: %v", err)
+	}
+	h, err := ReadHeader(pth)
+	if err != nil {
+		t.Fatal(err)
+	} else if h.Zoom != 3 || h.MimeType != `image/webp` || h.TileSize != DefaultTileSize || h.Metadata.Name != md.Name {
+		t.Fatalf("bad header: %+v", h)
+	}
+
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if rdr.Zoom() != 3 {
+		t.Fatalf("bad zoom %d", rdr.Zoom())
+	}
+	if rb, err := rdr.GetTile(1, 2); err != nil {
+		t.Fatal(err)
+	} else if string(rb) != string(buff) {
+		t.Fatal("bad tile")
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTilemapLegacy(t *testing.T) {
+	pth := filepath.Join(tdir, `legacy`)
+	zl := 1
+	buff := randTile(basicBuff)
+	//hand build a headerless file with a single tile at 1/1
+	dpRegion := int64(tileCount(zl) * dpsize)
+	raw := make([]byte, dpRegion)
+	dp := datapointer{offset: dpRegion, size: int64(len(buff))}
+	if err := dp.Encode(raw[tileid(zl, 1, 1)*dpsize:]); err != nil {
+		t.Fatal(err)
+	}
+	raw = append(raw, buff...)
+	if err := os.WriteFile(pth, raw, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadHeader(pth); err != ErrNoHeader {
+		t.Fatalf("Failed to catch legacy file: %v", err)
+	} else if _, err = OpenTilemap(pth, true); err != ErrNoHeader {
+		t.Fatalf("Failed to catch legacy file without zoom: %v", err)
+	}
+
+	wtr, err := NewTilemap(pth, zl, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if h := wtr.Header(); !h.Legacy() {
+		t.Fatalf("legacy file reported a header: %+v", h)
+	}
+	if rb, err := wtr.GetTile(1, 1); err != nil {
+		t.Fatal(err)
+	} else if string(rb) != string(buff) {
+		t.Fatal("bad legacy tile")
+	}
+	buff2 := randTile(basicBuff)
+	if err = wtr.Add(0, 1, buff2); err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := NewTilemap(pth, zl, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rb, err := rdr.GetTile(0, 1); err != nil {
+		t.Fatal(err)
+	} else if string(rb) != string(buff2) {
+		t.Fatal("bad legacy tile")
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
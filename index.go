@@ -0,0 +1,243 @@
+package tilemap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// IndexType selects how tile datapointers are laid out in the file
+type IndexType uint8
+
+const (
+	// IndexDense reserves a datapointer for every tile, 10 bytes * 4^zoom
+	IndexDense IndexType = 0
+	// IndexSparse stores populated tiles in a hash table, always used above zoom 16
+	IndexSparse IndexType = 1
+
+	sparseKeySize     = 6 //tile id + 1, zero marks an empty slot
+	sparseEntrySize   = sparseKeySize + dpsize
+	sparseInitSlots   = 4096
+	sparseLoadPercent = 75 //grow once this much of the table is in use
+)
+
+var (
+	ErrInvalidIndexType = errors.New("invalid tile index type")
+)
+
+func (t IndexType) String() string {
+	switch t {
+	case IndexDense:
+		return `dense`
+	case IndexSparse:
+		return `sparse`
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(t))
+}
+
+// ParseIndexType converts a name from String back to an IndexType
+func ParseIndexType(s string) (t IndexType, err error) {
+	switch s {
+	case ``, `dense`:
+		t = IndexDense
+	case `sparse`:
+		t = IndexSparse
+	default:
+		err = ErrInvalidIndexType
+	}
+	return
+}
+
+// sparseSlots returns the table size for n populated tiles, leaving the table at most half full
+func sparseSlots(n int64) (slots int64) {
+	for slots = sparseInitSlots; slots < n*2; slots *= 2 {
+	}
+	return
+}
+
+func sparseHash(key uint64) uint64 {
+	key ^= key >> 33
+	key *= 0xff51afd7ed558ccd
+	key ^= key >> 33
+	return key
+}
+
+func sparseKey(b []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint16(b)) | uint64(binary.LittleEndian.Uint32(b[2:]))<<16
+}
+
+func putSparseKey(b []byte, key uint64) {
+	binary.LittleEndian.PutUint16(b, uint16(key))
+	binary.LittleEndian.PutUint32(b[2:], uint32(key>>16))
+}
+
+// loadSparseKey reads the key of the entry at off in a table that may be shared with a writer
+func loadSparseKey(idx []byte, off int64) uint64 {
+	var b [sparseKeySize]byte
+	loadShared(b[:], idx, int(off))
+	return sparseKey(b[:])
+}
+
+// mapIndex maps the sparse index table described by hdr and counts the slots in use
+func (w *Tilemap) mapIndex(hdr Header) (err error) {
+	var r region
+	if r, err = w.st.mapRegion(hdr.idxOffset, hdr.indexSize(), !w.ro); err != nil {
+		return
+	}
+	idx := r.bytes()
+	var used int64
+	for off := 0; off < len(idx); off += sparseEntrySize {
+		if sparseKey(idx[off:]) != 0 {
+			used++
+		}
+	}
+	if w.ireg != nil {
+		w.ireg.unmap()
+	}
+	w.ireg = r
+	w.idx = idx
+	w.used = used
+	return
+}
+
+// probe finds the slot holding tid, or the empty slot where it would be inserted if ok is false
+func probe(idx []byte, tid uint64) (slot int64, ok bool) {
+	slots := uint64(len(idx) / sparseEntrySize)
+	if slots == 0 {
+		slot = -1
+		return
+	}
+	key := tid + 1
+	i := sparseHash(key) & (slots - 1)
+	for n := uint64(0); n < slots; n++ {
+		switch loadSparseKey(idx, int64(i*sparseEntrySize)) {
+		case key:
+			return int64(i), true
+		case 0:
+			return int64(i), false
+		}
+		i = (i + 1) & (slots - 1)
+	}
+	slot = -1 //full table, only possible if the file is corrupt
+	return
+}
+
+func (w *Tilemap) getSparse(tid uint64) (dp datapointer, err error) {
+	if tid >= uint64(w.hdr.tileCount()) {
+		err = fmt.Errorf("%v %d", errorLine(ErrInvalidTileID), tid)
+		return
+	}
+	w.readIndex(func() {
+		dp = datapointer{}
+		if slot, ok := probe(w.idx, tid); ok {
+			dp = loadDataPointer(w.idx, slot*sparseEntrySize+sparseKeySize)
+		}
+	})
+	return
+}
+
+// setSparse updates the entry for tid, cleared tiles keep their slot so probe chains stay intact
+func (w *Tilemap) setSparse(tid uint64, dp datapointer) (err error) {
+	if tid >= uint64(w.hdr.tileCount()) {
+		err = fmt.Errorf("%v %d", errorLine(ErrInvalidTileID), tid)
+		return
+	}
+	slot, ok := probe(w.idx, tid)
+	if ok {
+		w.writeIndex(func() {
+			storeDataPointer(w.idx, slot*sparseEntrySize+sparseKeySize, dp)
+		})
+		return
+	} else if dp.size == 0 {
+		return //nothing to clear
+	}
+	if slot < 0 || (w.used+1)*100 > w.hdr.idxSlots*sparseLoadPercent {
+		if err = w.growSparse(); err != nil {
+			err = errorLine(err)
+			return
+		}
+		slot, _ = probe(w.idx, tid)
+	}
+	var ent [sparseEntrySize]byte
+	putSparseKey(ent[:], tid+1)
+	dp.Encode(ent[sparseKeySize:])
+	w.writeIndex(func() {
+		storeShared(w.idx, int(slot*sparseEntrySize), ent[:])
+	})
+	w.used++
+	return
+}
+
+// walkSparse calls fn for every populated entry in table order
+func (w *Tilemap) walkSparse(fn func(tid uint64, dp datapointer) error) (err error) {
+	var ent [sparseEntrySize]byte
+	var dp datapointer
+	for off := 0; off+sparseEntrySize <= len(w.idx); off += sparseEntrySize {
+		w.readIndex(func() {
+			loadShared(ent[:], w.idx, off)
+		})
+		key := sparseKey(ent[:])
+		if key == 0 {
+			continue
+		} else if err = dp.Decode(ent[sparseKeySize:]); err != nil {
+			return
+		} else if dp.size == 0 {
+			continue //cleared
+		}
+		if err = fn(key-1, dp); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// growSparse rehashes the populated entries into a larger table at the end of the file.
+// The old table is left for readers that have not refreshed and is reclaimed by Compact.
+func (w *Tilemap) growSparse() (err error) {
+	//the new table goes at the end of the file, buffered blobs must land before it
+	if err = w.flushData(); err != nil {
+		return
+	}
+	var live int64
+	w.walkSparse(func(uint64, datapointer) error {
+		live++
+		return nil
+	})
+	hdr := w.hdr
+	hdr.idxOffset = (w.foff + 7) &^ 7 //keep entries on whole words
+	hdr.idxSlots = sparseSlots(live + 1)
+	end := hdr.idxOffset + hdr.indexSize()
+	if err = w.st.grow(end); err != nil {
+		return
+	}
+	var r region
+	if r, err = w.st.mapRegion(hdr.idxOffset, hdr.indexSize(), true); err != nil {
+		return
+	}
+	idx := r.bytes()
+	w.walkSparse(func(tid uint64, dp datapointer) error {
+		slot, _ := probe(idx, tid)
+		ent := idx[slot*sparseEntrySize:]
+		putSparseKey(ent, tid+1)
+		return dp.Encode(ent[sparseKeySize:])
+	})
+	if err = r.sync(0); err == nil {
+		if err = hdr.Encode(w.mm); err == nil {
+			err = w.hreg.sync(headerSize)
+		}
+	}
+	if err != nil {
+		r.unmap()
+		return
+	}
+	w.ireg.unmap()
+	w.ireg = r
+	w.idx = idx
+	w.used = live
+	w.hdr = hdr
+	w.foff = end
+	if w.mapped && w.foff > int64(len(w.dmm)) {
+		err = w.mapData()
+	}
+	return
+}
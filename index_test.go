@@ -0,0 +1,155 @@
+package tilemap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseIndex(t *testing.T) {
+	zl := 20
+	pth := filepath.Join(tdir, `sparse`)
+	wtr, err := NewTilemap(pth, zl, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if h := wtr.Header(); h.Index != IndexSparse {
+		t.Fatalf("zoom %d did not pick the sparse index: %v", zl, h.Index)
+	}
+	rdr, err := NewTilemapConfig(pth, Config{Zoom: zl, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//enough tiles spread across the map to force the table to grow a few times
+	expected := make(map[uint64][]byte)
+	dim := 1 << zl
+	shared := []byte(`shared tile`)
+	for i := 0; i < 3*sparseInitSlots; i++ {
+		x, y := (i*7919)%dim, dim-1-(i*104729)%dim
+		buff := []byte(fmt.Sprintf("tile %d %d", x, y))
+		if i%3 == 0 {
+			buff = shared
+		}
+		if err = wtr.Add(x, y, buff); err != nil {
+			t.Fatal(err)
+		}
+		expected[tileid(zl, x, y)] = buff
+	}
+	if h := wtr.Header(); h.idxSlots <= sparseInitSlots {
+		t.Fatalf("sparse index did not grow: %d slots", h.idxSlots)
+	}
+	if _, err = wtr.GetTile(dim-1, 0); err != ErrTileNotFound {
+		t.Fatalf("empty tile did not report ErrTileNotFound: %v", err)
+	} else if _, err = wtr.GetTile(dim, 0); err != ErrInvalidTileID {
+		t.Fatalf("failed to catch out of range tile: %v", err)
+	}
+	checkSparse(t, wtr, expected)
+
+	//readers follow the table as it moves
+	if err = rdr.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	checkSparse(t, rdr, expected)
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//delete a few, then reopen and make sure dedup picked up where it left off
+	var deleted uint64
+	for tid := range expected {
+		deleted = tid
+		x, y := int(tid)/dim, int(tid)%dim
+		if err = wtr.Delete(x, y); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, tid)
+		if len(expected) < 2*sparseInitSlots {
+			break
+		}
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if wtr, err = OpenTilemap(pth, false); err != nil {
+		t.Fatal(err)
+	}
+	checkSparse(t, wtr, expected)
+	//reuse a deleted slot so the table does not need to grow
+	sz := wtr.size()
+	if err = wtr.Add(int(deleted)/dim, int(deleted)%dim, shared); err != nil {
+		t.Fatal(err)
+	} else if wtr.size() != sz {
+		t.Fatal("duplicate tile was not deduplicated after reopen")
+	}
+	expected[deleted] = shared
+
+	//compaction drops the old tables and deleted tiles
+	if err = wtr.Compact(); err != nil {
+		t.Fatal(err)
+	} else if dead, err := wtr.DeadBytes(); err != nil {
+		t.Fatal(err)
+	} else if dead != 0 {
+		t.Fatalf("compacted sparse tilemap has %d dead bytes", dead)
+	} else if wtr.size() >= sz {
+		t.Fatalf("compaction did not shrink the file: %d >= %d", wtr.size(), sz)
+	}
+	checkSparse(t, wtr, expected)
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(pth); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() > 1024*1024 {
+		t.Fatalf("sparse tilemap is too large: %d", fi.Size())
+	}
+}
+
+func TestSparseIndexLowZoom(t *testing.T) {
+	zl := 4
+	pth := filepath.Join(tdir, `sparselow`)
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Index: IndexSparse})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff := randTile(basicBuff)
+	if err = wtr.Add(3, 9, buff); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := ReadHeader(pth); err != nil {
+		t.Fatal(err)
+	} else if h.Index != IndexSparse {
+		t.Fatalf("requested sparse index was not used: %v", h.Index)
+	}
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rb, err := rdr.GetTile(3, 9); err != nil {
+		t.Fatal(err)
+	} else if string(rb) != string(buff) {
+		t.Fatal("bad tile")
+	} else if rdr.Has(9, 3) {
+		t.Fatal("unpopulated tile reported as present")
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkSparse(t *testing.T, tm *Tilemap, expected map[uint64][]byte) {
+	dim := 1 << uint(tm.Zoom())
+	for tid, want := range expected {
+		x, y := int(tid)/dim, int(tid)%dim
+		if buff, err := tm.GetTile(x, y); err != nil {
+			t.Fatalf("%d/%d %v", x, y, err)
+		} else if string(buff) != string(want) {
+			t.Fatalf("bad tile at %d/%d", x, y)
+		}
+	}
+	if n := tm.count(); n != int64(len(expected)) {
+		t.Fatalf("bad tile count %d != %d", n, len(expected))
+	}
+}
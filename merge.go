@@ -0,0 +1,194 @@
+package tilemap
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"sort"
+)
+
+var (
+	ErrInvalidMergeMode   = errors.New("invalid merge mode")
+	ErrMergeMismatch      = errors.New("merged tilemaps must share a zoom and format")
+	ErrOverlayUnsupported = errors.New("tile format cannot be overlaid")
+)
+
+// MergeMode controls how tiles present in more than one source are combined
+type MergeMode int
+
+const (
+	MergeFirst   MergeMode = iota //take the tile from the first source that has it
+	MergeOverlay                  //alpha composite every source that has the tile, the first source on top
+)
+
+func (m MergeMode) String() string {
+	switch m {
+	case MergeFirst:
+		return `first`
+	case MergeOverlay:
+		return `overlay`
+	}
+	return fmt.Sprintf("unknown(%d)", int(m))
+}
+
+// ParseMergeMode converts a name from String back to a MergeMode
+func ParseMergeMode(s string) (m MergeMode, err error) {
+	switch s {
+	case ``, `first`:
+		m = MergeFirst
+	case `overlay`:
+		m = MergeOverlay
+	default:
+		err = ErrInvalidMergeMode
+	}
+	return
+}
+
+// Merge combines tilemaps at the same zoom into a new tilemap at pth, srcs are in order of precedence.
+// Settings c leaves empty come from the sources, see MergeMode for how tiles are combined.
+func Merge(srcs []*Tilemap, pth string, c Config, mode MergeMode) (err error) {
+	if len(srcs) == 0 {
+		err = errors.New("no tilemaps to merge")
+		return
+	} else if mode != MergeFirst && mode != MergeOverlay {
+		err = ErrInvalidMergeMode
+		return
+	}
+	hdr := srcs[0].Header()
+	f := srcs[0].Format()
+	region := hdr.Region
+	for _, src := range srcs[1:] {
+		if src.Zoom() != hdr.Zoom {
+			err = ErrMergeMismatch
+			return
+		} else if sf := src.Format(); f == FormatUnknown {
+			f = sf
+		} else if sf != FormatUnknown && sf != f {
+			err = ErrMergeMismatch
+			return
+		}
+		region = region.union(src.Header().Region)
+	}
+	if mode == MergeOverlay && f != FormatPNG && f != FormatJPEG {
+		err = ErrOverlayUnsupported
+		return
+	}
+	if f != hdr.Format {
+		//the format came from another source or from the tiles themselves
+		hdr.Format = f
+		hdr.MimeType = f.MimeType()
+	}
+	hdr.Region = region
+	c = inheritConfig(hdr, c)
+	if err = ensureAbsent(pth); err != nil {
+		return
+	}
+
+	//each tile is owned by the first source that has it, ordered along the destination curve
+	type entry struct {
+		tid  uint64
+		x, y int
+		src  int
+	}
+	var ents []entry
+	bounds := c.Region.Bounds(hdr.Zoom)
+	for i, src := range srcs {
+	tiles:
+		for t := range src.Tiles(WalkOptions{Bounds: &bounds}) {
+			for _, prev := range srcs[:i] {
+				if prev.Has(t.X, t.Y) {
+					continue tiles
+				}
+			}
+			ents = append(ents, entry{tid: c.Region.tileid(c.Curve, hdr.Zoom, t.X, t.Y), x: t.X, y: t.Y, src: i})
+		}
+	}
+	sort.Slice(ents, func(i, j int) bool {
+		return ents[i].tid < ents[j].tid
+	})
+
+	var dst *Tilemap
+	if dst, err = NewTilemapConfig(pth, c); err != nil {
+		return
+	}
+	batch := make([]Tile, 0, rewriteBatch)
+	for i, e := range ents {
+		var buff []byte
+		if mode == MergeOverlay {
+			buff, err = overlayTile(srcs[e.src:], e.x, e.y, f)
+		} else {
+			buff, err = srcs[e.src].GetTile(e.x, e.y)
+		}
+		if err != nil {
+			err = fmt.Errorf("Failed to merge tile %d/%d: %v", e.x, e.y, err)
+			break
+		}
+		batch = append(batch, Tile{X: e.x, Y: e.y, Data: buff})
+		if len(batch) == rewriteBatch || i == len(ents)-1 {
+			if err = dst.AddBatch(batch); err != nil {
+				break
+			}
+			batch = batch[:0]
+		}
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(pth)
+		return
+	}
+	err = dst.Close()
+	return
+}
+
+// overlayTile composites the tile at x, y from every source that has it, srcs[0] must have the
+// tile and is drawn on top.  Layers below the first opaque layer are never read.
+func overlayTile(srcs []*Tilemap, x, y int, f Format) (r []byte, err error) {
+	var top []byte
+	if top, err = srcs[0].GetTile(x, y); err != nil {
+		return
+	}
+	var layers []*image.RGBA
+	for _, src := range srcs[1:] {
+		var buff []byte
+		if !src.Has(x, y) {
+			continue
+		} else if len(layers) == 0 {
+			//only decode the top once there is something beneath it
+			var img *image.RGBA
+			if img, err = decodeRaster(top, f); err != nil {
+				return
+			} else if img.Opaque() {
+				r = top
+				return
+			}
+			layers = append(layers, img)
+		}
+		if buff, err = src.GetTile(x, y); err != nil {
+			return
+		}
+		var img *image.RGBA
+		if img, err = decodeRaster(buff, f); err != nil {
+			return
+		} else if img.Rect != layers[0].Rect {
+			err = ErrTileSizeMismatch
+			return
+		}
+		layers = append(layers, img)
+		if img.Opaque() {
+			break
+		}
+	}
+	if len(layers) == 0 {
+		r = top
+		return
+	}
+	//draw from the bottom up
+	dst := layers[len(layers)-1]
+	for i := len(layers) - 2; i >= 0; i-- {
+		draw.Draw(dst, dst.Rect, layers[i], image.Point{}, draw.Over)
+	}
+	r, err = encodeRaster(dst, f)
+	return
+}
@@ -0,0 +1,133 @@
+package tilemap
+
+import (
+	"bytes"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	const sz = 4
+	white := solidPNG(t, sz, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	red := solidPNG(t, sz, color.RGBA{R: 255, A: 255})
+	clearRed := solidPNG(t, sz, color.RGBA{R: 128, A: 128}) //premultiplied
+
+	base, err := NewTilemapConfig(filepath.Join(tdir, `mergebase`), Config{Zoom: 2, Format: FormatPNG})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = base.AddBatch([]Tile{{0, 0, white}, {1, 0, white}, {1, 1, white}, {3, 3, white}}); err != nil {
+		t.Fatal(err)
+	}
+	over, err := NewTilemapConfig(filepath.Join(tdir, `mergeover`), Config{Zoom: 2, Format: FormatPNG, Region: Region{Width: 2, Height: 2}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = over.AddBatch([]Tile{{0, 0, clearRed}, {1, 1, red}, {0, 1, clearRed}}); err != nil {
+		t.Fatal(err)
+	}
+
+	//the first source wins
+	pth := filepath.Join(tdir, `mergefirst`)
+	if err = Merge([]*Tilemap{over, base}, pth, Config{}, MergeFirst); err != nil {
+		t.Fatal(err)
+	} else if err = Merge([]*Tilemap{over, base}, pth, Config{}, MergeFirst); err == nil {
+		t.Fatal("Failed to catch existing output")
+	}
+	m, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if hdr := m.Header(); hdr.Region.Set() || hdr.Format != FormatPNG {
+		t.Fatalf("bad merged header %+v", hdr)
+	}
+	for _, tt := range []struct {
+		x, y int
+		buff []byte
+	}{{0, 0, clearRed}, {1, 0, white}, {1, 1, red}, {0, 1, clearRed}, {3, 3, white}} {
+		if buff, err := m.GetTile(tt.x, tt.y); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buff, tt.buff) {
+			t.Fatalf("bad tile at %d %d", tt.x, tt.y)
+		}
+	}
+	if st, err := m.Stats(); err != nil {
+		t.Fatal(err)
+	} else if st.Tiles != 5 || st.UniqueBlobs != 3 {
+		t.Fatalf("shared blobs were not deduplicated %+v", st)
+	} else if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//overlays are drawn over what is beneath them
+	pth = filepath.Join(tdir, `mergeoverlay`)
+	if err = Merge([]*Tilemap{over, base}, pth, Config{}, MergeOverlay); err != nil {
+		t.Fatal(err)
+	} else if m, err = OpenTilemap(pth, true); err != nil {
+		t.Fatal(err)
+	}
+	if buff, err := m.GetTile(0, 0); err != nil {
+		t.Fatal(err)
+	} else if img, err := decodeRaster(buff, FormatPNG); err != nil {
+		t.Fatal(err)
+	} else if c := img.RGBAAt(1, 1); c.R != 255 || !near8(c.G, 127, 1) || c.A != 255 {
+		t.Fatalf("bad composite %v", c)
+	}
+	//an opaque top tile and a lone transparent tile are copied as is
+	if buff, err := m.GetTile(1, 1); err != nil || !bytes.Equal(buff, red) {
+		t.Fatalf("opaque tile was not copied %v", err)
+	} else if buff, err = m.GetTile(0, 1); err != nil || !bytes.Equal(buff, clearRed) {
+		t.Fatalf("lone tile was not copied %v", err)
+	} else if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//regional sources merge into a region that covers both
+	other, err := NewTilemapConfig(filepath.Join(tdir, `mergeregion`), Config{Zoom: 2, Format: FormatPNG, Region: Region{X: 3, Y: 1, Width: 1, Height: 2}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = other.Add(3, 2, white); err != nil {
+		t.Fatal(err)
+	}
+	pth = filepath.Join(tdir, `mergeregions`)
+	if err = Merge([]*Tilemap{over, other}, pth, Config{}, MergeFirst); err != nil {
+		t.Fatal(err)
+	} else if hdr, err := ReadHeader(pth); err != nil {
+		t.Fatal(err)
+	} else if hdr.Region != (Region{Width: 4, Height: 3}) {
+		t.Fatalf("bad merged region %+v", hdr.Region)
+	}
+
+	//a format taken from a later source is what the merged file records
+	raw, err := NewTilemapConfig(filepath.Join(tdir, `mergeraw`), Config{Zoom: 2, MimeType: `application/octet-stream`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pth = filepath.Join(tdir, `mergeformat`)
+	if err = Merge([]*Tilemap{raw, base}, pth, Config{}, MergeFirst); err != nil {
+		t.Fatal(err)
+	} else if hdr, err := ReadHeader(pth); err != nil {
+		t.Fatal(err)
+	} else if hdr.Format != FormatPNG || hdr.MimeType != FormatPNG.MimeType() {
+		t.Fatalf("bad merged format %v %q", hdr.Format, hdr.MimeType)
+	}
+
+	//sources have to agree
+	z3, err := NewTilemapConfig(filepath.Join(tdir, `mergez3`), Config{Zoom: 3})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = Merge([]*Tilemap{base, z3}, filepath.Join(tdir, `mergebad`), Config{}, MergeFirst); err != ErrMergeMismatch {
+		t.Fatalf("Failed to catch mismatched zoom: %v", err)
+	}
+	mvt, err := NewTilemapConfig(filepath.Join(tdir, `mergemvt`), Config{Zoom: 2, Format: FormatMVT})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = Merge([]*Tilemap{base, mvt}, filepath.Join(tdir, `mergebad`), Config{}, MergeFirst); err != ErrMergeMismatch {
+		t.Fatalf("Failed to catch mismatched format: %v", err)
+	} else if err = Merge([]*Tilemap{mvt}, filepath.Join(tdir, `mergebad`), Config{}, MergeOverlay); err != ErrOverlayUnsupported {
+		t.Fatalf("Failed to catch unsupported overlay: %v", err)
+	}
+	for _, tm := range []*Tilemap{base, over, other, raw, z3, mvt} {
+		if err = tm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
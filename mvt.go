@@ -0,0 +1,460 @@
+package tilemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Just enough of the mapbox vector tile encoding to clip and rescale geometry, every other
+// field in the tile, its layers, and their features is copied through untouched.
+const (
+	mvtTileLayers    = 3
+	mvtLayerFeatures = 2
+	mvtLayerExtent   = 5
+	mvtFeatureType   = 3
+	mvtFeatureGeom   = 4
+
+	mvtPoint      = 1
+	mvtLineString = 2
+	mvtPolygon    = 3
+
+	mvtMoveTo    = 1
+	mvtLineTo    = 2
+	mvtClosePath = 7
+
+	mvtDefaultExtent = 4096
+	mvtBufferDiv     = 64 //keep geometry within extent/64 of the tile edge so renderers can stroke across it
+
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+var (
+	ErrInvalidMVT = errors.New("invalid vector tile")
+)
+
+type pbField struct {
+	num  uint64
+	wire uint64
+	raw  []byte //the entire field, key included
+	val  []byte //payload of length delimited fields
+	v    uint64 //value of varint fields
+}
+
+// pbFields calls fn for every field in a protobuf message
+func pbFields(b []byte, fn func(f pbField) error) (err error) {
+	for len(b) > 0 {
+		var f pbField
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrInvalidMVT
+		}
+		f.num, f.wire = key>>3, key&0x7
+		off := n
+		switch f.wire {
+		case pbVarint:
+			if f.v, n = binary.Uvarint(b[off:]); n <= 0 {
+				return ErrInvalidMVT
+			}
+			off += n
+		case pbFixed64:
+			off += 8
+		case pbFixed32:
+			off += 4
+		case pbBytes:
+			var sz uint64
+			if sz, n = binary.Uvarint(b[off:]); n <= 0 || sz > uint64(len(b)-off-n) {
+				return ErrInvalidMVT
+			}
+			off += n
+			f.val = b[off : off+int(sz)]
+			off += int(sz)
+		default:
+			return ErrInvalidMVT
+		}
+		if off > len(b) {
+			return ErrInvalidMVT
+		}
+		f.raw = b[:off]
+		b = b[off:]
+		if err = fn(f); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func appendBytesField(b []byte, num uint64, val []byte) []byte {
+	b = binary.AppendUvarint(b, num<<3|pbBytes)
+	b = binary.AppendUvarint(b, uint64(len(val)))
+	return append(b, val...)
+}
+
+type fpoint struct {
+	x, y float64
+}
+
+// mvtClip is the window of the source tile that becomes the new tile
+type mvtClip struct {
+	minX, minY, maxX, maxY float64 //buffered window in source units
+	ox, oy                 float64 //origin of the window
+	scale                  float64 //source units to new tile units
+}
+
+// overzoomMVT clips a vector tile to the descendant sx, sy dz levels down and rescales it to the
+// tile extent.  Gzipped tiles come back gzipped.
+func overzoomMVT(buff []byte, dz, sx, sy int) (r []byte, err error) {
+	gz := bytes.HasPrefix(buff, gzipMagic)
+	if gz {
+		if buff, err = CompressGzip.decompress(buff); err != nil {
+			return
+		}
+	}
+	err = pbFields(buff, func(f pbField) error {
+		if f.num != mvtTileLayers || f.wire != pbBytes {
+			r = append(r, f.raw...)
+			return nil
+		}
+		layer, err := clipLayer(f.val, dz, sx, sy)
+		if err == nil && layer != nil {
+			r = appendBytesField(r, mvtTileLayers, layer)
+		}
+		return err
+	})
+	if err != nil {
+		r = nil
+		return
+	}
+	if gz {
+		var bb bytes.Buffer
+		gzw := gzip.NewWriter(&bb)
+		if _, err = gzw.Write(r); err == nil {
+			err = gzw.Close()
+		}
+		r = bb.Bytes()
+	}
+	return
+}
+
+// clipLayer returns the layer with its features clipped, nil if no features are left
+func clipLayer(b []byte, dz, sx, sy int) (r []byte, err error) {
+	extent := float64(mvtDefaultExtent)
+	pbFields(b, func(f pbField) error {
+		if f.num == mvtLayerExtent && f.wire == pbVarint && f.v > 0 {
+			extent = float64(f.v)
+		}
+		return nil
+	})
+	size := extent / float64(uint64(1)<<uint(dz))
+	buf := extent / mvtBufferDiv / float64(uint64(1)<<uint(dz))
+	c := mvtClip{
+		ox:    float64(sx) * size,
+		oy:    float64(sy) * size,
+		scale: float64(uint64(1) << uint(dz)),
+	}
+	c.minX, c.minY = c.ox-buf, c.oy-buf
+	c.maxX, c.maxY = c.ox+size+buf, c.oy+size+buf
+
+	var features int
+	err = pbFields(b, func(f pbField) error {
+		if f.num != mvtLayerFeatures || f.wire != pbBytes {
+			r = append(r, f.raw...)
+			return nil
+		}
+		feat, err := c.feature(f.val)
+		if err == nil && feat != nil {
+			r = appendBytesField(r, mvtLayerFeatures, feat)
+			features++
+		}
+		return err
+	})
+	if err != nil || features == 0 {
+		r = nil
+	}
+	return
+}
+
+// feature returns the feature with its geometry clipped, nil if nothing is left
+func (c mvtClip) feature(b []byte) (r []byte, err error) {
+	var typ uint64
+	var geom []byte
+	err = pbFields(b, func(f pbField) error {
+		if f.num == mvtFeatureType && f.wire == pbVarint {
+			typ = f.v
+		} else if f.num == mvtFeatureGeom && f.wire == pbBytes {
+			geom = f.val
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	var paths [][]fpoint
+	if paths, err = decodeGeometry(geom); err != nil {
+		return
+	}
+	var out [][]fpoint
+	for _, p := range paths {
+		switch typ {
+		case mvtPoint:
+			for _, pt := range p {
+				if c.contains(pt) {
+					out = append(out, []fpoint{pt})
+				}
+			}
+		case mvtLineString:
+			out = append(out, c.clipLine(p)...)
+		case mvtPolygon:
+			if ring := c.clipRing(p); len(ring) >= 3 {
+				out = append(out, ring)
+			}
+		default:
+			return b, nil //unknown geometry is passed through
+		}
+	}
+	if geom = c.encodeGeometry(typ, out); geom == nil {
+		return
+	}
+	err = pbFields(b, func(f pbField) error {
+		if f.num != mvtFeatureGeom {
+			r = append(r, f.raw...)
+		}
+		return nil
+	})
+	r = appendBytesField(r, mvtFeatureGeom, geom)
+	return
+}
+
+func (c mvtClip) contains(p fpoint) bool {
+	return p.x >= c.minX && p.x <= c.maxX && p.y >= c.minY && p.y <= c.maxY
+}
+
+// clipLine cuts a line string down to the pieces inside the window
+func (c mvtClip) clipLine(pts []fpoint) (r [][]fpoint) {
+	var cur []fpoint
+	for i := 1; i < len(pts); i++ {
+		a, b, ok := c.clipSegment(pts[i-1], pts[i])
+		if !ok {
+			if len(cur) > 1 {
+				r = append(r, cur)
+			}
+			cur = nil
+			continue
+		}
+		if len(cur) == 0 || cur[len(cur)-1] != a {
+			if len(cur) > 1 {
+				r = append(r, cur)
+			}
+			cur = []fpoint{a}
+		}
+		cur = append(cur, b)
+		if b != pts[i] {
+			//the line left the window
+			r = append(r, cur)
+			cur = nil
+		}
+	}
+	if len(cur) > 1 {
+		r = append(r, cur)
+	}
+	return
+}
+
+// clipSegment is Liang-Barsky, ok is false if the segment misses the window
+func (c mvtClip) clipSegment(p0, p1 fpoint) (a, b fpoint, ok bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := p1.x-p0.x, p1.y-p0.y
+	for _, e := range [4][2]float64{
+		{-dx, p0.x - c.minX},
+		{dx, c.maxX - p0.x},
+		{-dy, p0.y - c.minY},
+		{dy, c.maxY - p0.y},
+	} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			if q < 0 {
+				return
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return
+			}
+			t0 = max(t0, t)
+		} else {
+			if t < t0 {
+				return
+			}
+			t1 = min(t1, t)
+		}
+	}
+	a, b, ok = p0, p1, true
+	if t0 > 0 {
+		a = fpoint{p0.x + t0*dx, p0.y + t0*dy}
+	}
+	if t1 < 1 {
+		b = fpoint{p0.x + t1*dx, p0.y + t1*dy}
+	}
+	return
+}
+
+// clipRing is Sutherland-Hodgman against each edge of the window, winding order is preserved
+func (c mvtClip) clipRing(pts []fpoint) []fpoint {
+	for edge := 0; edge < 4 && len(pts) > 0; edge++ {
+		in := pts
+		pts = nil
+		prev := in[len(in)-1]
+		for _, cur := range in {
+			ci, pi := c.inside(cur, edge), c.inside(prev, edge)
+			if ci {
+				if !pi {
+					pts = append(pts, c.intersect(prev, cur, edge))
+				}
+				pts = append(pts, cur)
+			} else if pi {
+				pts = append(pts, c.intersect(prev, cur, edge))
+			}
+			prev = cur
+		}
+	}
+	return pts
+}
+
+func (c mvtClip) inside(p fpoint, edge int) bool {
+	switch edge {
+	case 0:
+		return p.x >= c.minX
+	case 1:
+		return p.x <= c.maxX
+	case 2:
+		return p.y >= c.minY
+	}
+	return p.y <= c.maxY
+}
+
+func (c mvtClip) intersect(a, b fpoint, edge int) fpoint {
+	var v float64
+	switch edge {
+	case 0:
+		v = c.minX
+	case 1:
+		v = c.maxX
+	case 2:
+		v = c.minY
+	default:
+		v = c.maxY
+	}
+	if edge < 2 {
+		return fpoint{v, a.y + (b.y-a.y)*(v-a.x)/(b.x-a.x)}
+	}
+	return fpoint{a.x + (b.x-a.x)*(v-a.y)/(b.y-a.y), v}
+}
+
+// decodeGeometry returns the paths in an encoded geometry, every MoveTo starts a new path
+func decodeGeometry(b []byte) (paths [][]fpoint, err error) {
+	var vals []uint32
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 || v > math.MaxUint32 {
+			return nil, ErrInvalidMVT
+		}
+		vals = append(vals, uint32(v))
+		b = b[n:]
+	}
+	var x, y int64
+	for i := 0; i < len(vals); {
+		id, count := vals[i]&0x7, int(vals[i]>>3)
+		i++
+		switch id {
+		case mvtMoveTo, mvtLineTo:
+			if count*2 > len(vals)-i || (id == mvtLineTo && len(paths) == 0) {
+				return nil, ErrInvalidMVT
+			}
+			for j := 0; j < count; j++ {
+				x += int64(unzigzag(vals[i]))
+				y += int64(unzigzag(vals[i+1]))
+				i += 2
+				pt := fpoint{float64(x), float64(y)}
+				if id == mvtMoveTo {
+					paths = append(paths, []fpoint{pt})
+				} else {
+					paths[len(paths)-1] = append(paths[len(paths)-1], pt)
+				}
+			}
+		case mvtClosePath:
+			//rings are always treated as closed
+		default:
+			return nil, ErrInvalidMVT
+		}
+	}
+	return
+}
+
+// encodeGeometry moves the paths into the new tile and encodes them, nil if nothing is left
+func (c mvtClip) encodeGeometry(typ uint64, paths [][]fpoint) (b []byte) {
+	var x, y int32
+	var points []uint32
+	cmd := func(id, count int) {
+		b = binary.AppendUvarint(b, uint64(uint32(id)|uint32(count)<<3))
+	}
+	for _, p := range paths {
+		//move to the new tile and drop points that landed on top of each other
+		pts := make([][2]int32, 0, len(p))
+		for _, pt := range p {
+			q := [2]int32{
+				int32(math.Round((pt.x - c.ox) * c.scale)),
+				int32(math.Round((pt.y - c.oy) * c.scale)),
+			}
+			if len(pts) == 0 || pts[len(pts)-1] != q {
+				pts = append(pts, q)
+			}
+		}
+		if typ == mvtPolygon && len(pts) > 1 && pts[0] == pts[len(pts)-1] {
+			pts = pts[:len(pts)-1]
+		}
+		if (typ == mvtLineString && len(pts) < 2) || (typ == mvtPolygon && len(pts) < 3) {
+			continue
+		}
+		if typ == mvtPoint {
+			//all points share a single MoveTo
+			points = append(points, zigzag(pts[0][0]-x), zigzag(pts[0][1]-y))
+			x, y = pts[0][0], pts[0][1]
+			continue
+		}
+		cmd(mvtMoveTo, 1)
+		b = binary.AppendUvarint(b, uint64(zigzag(pts[0][0]-x)))
+		b = binary.AppendUvarint(b, uint64(zigzag(pts[0][1]-y)))
+		x, y = pts[0][0], pts[0][1]
+		cmd(mvtLineTo, len(pts)-1)
+		for _, pt := range pts[1:] {
+			b = binary.AppendUvarint(b, uint64(zigzag(pt[0]-x)))
+			b = binary.AppendUvarint(b, uint64(zigzag(pt[1]-y)))
+			x, y = pt[0], pt[1]
+		}
+		if typ == mvtPolygon {
+			cmd(mvtClosePath, 1)
+		}
+	}
+	if len(points) > 0 {
+		cmd(mvtMoveTo, len(points)/2)
+		for _, v := range points {
+			b = binary.AppendUvarint(b, uint64(v))
+		}
+	}
+	return
+}
+
+func zigzag(v int32) uint32 {
+	return uint32((v << 1) ^ (v >> 31))
+}
+
+func unzigzag(v uint32) int32 {
+	return int32(v>>1) ^ -int32(v&1)
+}
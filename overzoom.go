@@ -0,0 +1,262 @@
+package tilemap
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"sync"
+)
+
+const (
+	DefaultOverzoomLevels = 6                //how far past the nearest stored ancestor tiles are synthesized
+	DefaultOverzoomCache  = 64 * 1024 * 1024 //bytes of synthesized tiles kept in memory
+
+	rasterJPEGQuality = 90 //quality of re-encoded JPEG tiles
+)
+
+var (
+	ErrOverzoomUnsupported = errors.New("tile format cannot be overzoomed")
+	ErrInvalidOverzoom     = errors.New("invalid overzoom request")
+)
+
+// OverzoomTile synthesizes the descendant sx, sy dz levels below the ancestor tile in buff.
+// PNG, JPEG, and MVT tiles are supported.
+func OverzoomTile(buff []byte, f Format, dz, sx, sy int) (r []byte, err error) {
+	if dz < 0 || dz > MaxZoom || sx < 0 || sy < 0 || sx >= 1<<uint(dz) || sy >= 1<<uint(dz) {
+		err = ErrInvalidOverzoom
+		return
+	} else if dz == 0 {
+		r = bytes.Clone(buff)
+		return
+	}
+	switch f {
+	case FormatPNG, FormatJPEG:
+		r, err = overzoomRaster(buff, f, dz, sx, sy)
+	case FormatMVT:
+		r, err = overzoomMVT(buff, dz, sx, sy)
+	default:
+		err = ErrOverzoomUnsupported
+	}
+	return
+}
+
+// overzoomRaster crops the descendant out of the ancestor and scales it up with bilinear filtering
+func overzoomRaster(buff []byte, f Format, dz, sx, sy int) (r []byte, err error) {
+	var src *image.RGBA
+	if src, err = decodeRaster(buff, f); err != nil {
+		return
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	scale := 1 / float64(uint64(1)<<uint(dz))
+	ox, oy := float64(sx*w)*scale, float64(sy*h)*scale
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for py := 0; py < h; py++ {
+		v := oy + (float64(py)+0.5)*scale - 0.5
+		for px := 0; px < w; px++ {
+			u := ox + (float64(px)+0.5)*scale - 0.5
+			sampleBilinear(src, u, v, dst.Pix[dst.PixOffset(px, py):])
+		}
+	}
+	r, err = encodeRaster(dst, f)
+	return
+}
+
+// decodeRaster decodes a PNG or JPEG tile into an RGBA image anchored at 0, 0
+func decodeRaster(buff []byte, f Format) (r *image.RGBA, err error) {
+	var img image.Image
+	switch f {
+	case FormatPNG:
+		img, err = png.Decode(bytes.NewReader(buff))
+	case FormatJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(buff))
+	default:
+		err = ErrOverzoomUnsupported
+	}
+	if err != nil {
+		return
+	}
+	b := img.Bounds()
+	r = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(r, r.Bounds(), img, b.Min, draw.Src)
+	return
+}
+
+// encodeRaster encodes an image as a PNG or JPEG tile
+func encodeRaster(img image.Image, f Format) (r []byte, err error) {
+	var bb bytes.Buffer
+	if f == FormatPNG {
+		err = png.Encode(&bb, img)
+	} else {
+		err = jpeg.Encode(&bb, img, &jpeg.Options{Quality: rasterJPEGQuality})
+	}
+	if err == nil {
+		r = bb.Bytes()
+	}
+	return
+}
+
+// sampleBilinear writes the RGBA value at u, v into out, coordinates are clamped to the image
+func sampleBilinear(src *image.RGBA, u, v float64, out []uint8) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	x0, y0 := math.Floor(u), math.Floor(v)
+	fx, fy := u-x0, v-y0
+	ix0, iy0 := clampInt(int(x0), 0, w-1), clampInt(int(y0), 0, h-1)
+	ix1, iy1 := clampInt(int(x0)+1, 0, w-1), clampInt(int(y0)+1, 0, h-1)
+	p00 := src.Pix[src.PixOffset(ix0, iy0):]
+	p10 := src.Pix[src.PixOffset(ix1, iy0):]
+	p01 := src.Pix[src.PixOffset(ix0, iy1):]
+	p11 := src.Pix[src.PixOffset(ix1, iy1):]
+	for i := 0; i < 4; i++ {
+		top := float64(p00[i])*(1-fx) + float64(p10[i])*fx
+		bot := float64(p01[i])*(1-fx) + float64(p11[i])*fx
+		out[i] = uint8(math.Round(top*(1-fy) + bot*fy))
+	}
+}
+
+func clampInt(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+// OverzoomConfig controls how an Overzoomer synthesizes and caches tiles
+type OverzoomConfig struct {
+	MaxLevels int   //levels past the nearest stored ancestor to synthesize, defaults to DefaultOverzoomLevels
+	CacheSize int64 //bytes of synthesized tiles to keep, defaults to DefaultOverzoomCache, negative disables caching
+}
+
+// Overzoomer serves tiles from a tileset and synthesizes tiles above its highest stored zoom
+type Overzoomer struct {
+	ts     *Tileset
+	levels int
+	cache  *tileCache
+}
+
+// NewOverzoomer wraps a tileset, the tileset remains owned by the caller
+func NewOverzoomer(ts *Tileset, c OverzoomConfig) *Overzoomer {
+	if c.MaxLevels <= 0 {
+		c.MaxLevels = DefaultOverzoomLevels
+	}
+	if c.CacheSize == 0 {
+		c.CacheSize = DefaultOverzoomCache
+	}
+	return &Overzoomer{
+		ts:     ts,
+		levels: c.MaxLevels,
+		cache:  newTileCache(c.CacheSize),
+	}
+}
+
+// Source returns the stored zoom level whose format and MIME type apply to tiles at zoom
+func (o *Overzoomer) Source(zoom int) int {
+	if zooms := o.ts.Zooms(); len(zooms) > 0 && zoom > zooms[len(zooms)-1] {
+		return zooms[len(zooms)-1]
+	}
+	return zoom
+}
+
+// GetTile returns the tile at zoom, x, y, synthesized tiles are shared with the cache and must not be modified
+func (o *Overzoomer) GetTile(zoom, x, y int) (buff []byte, err error) {
+	zooms := o.ts.Zooms()
+	key := TileCoord{Zoom: zoom, X: x, Y: y}
+	if len(zooms) == 0 || zoom <= zooms[len(zooms)-1] {
+		return o.ts.GetTile(zoom, x, y)
+	} else if !key.Valid() {
+		err = ErrInvalidTileID
+		return
+	}
+	if buff = o.cache.get(key); buff != nil {
+		return
+	}
+	for i := len(zooms) - 1; i >= 0; i-- {
+		az := zooms[i]
+		dz := zoom - az
+		if dz > o.levels {
+			break
+		}
+		var anc []byte
+		if anc, err = o.ts.GetTile(az, x>>uint(dz), y>>uint(dz)); err == ErrTileNotFound {
+			continue //try further up
+		} else if err != nil {
+			return
+		}
+		mask := 1<<uint(dz) - 1
+		if buff, err = OverzoomTile(anc, o.ts.Format(az), dz, x&mask, y&mask); err == nil {
+			o.cache.add(key, buff)
+		}
+		return
+	}
+	err = ErrTileNotFound
+	return
+}
+
+// Purge drops every cached tile, call it after the tileset is refreshed
+func (o *Overzoomer) Purge() {
+	o.cache.purge()
+}
+
+// tileCache is a least recently used cache of tiles bounded by the bytes it holds
+type tileCache struct {
+	sync.Mutex
+	limit int64
+	size  int64
+	lru   *list.List //front is the most recently used
+	ents  map[TileCoord]*list.Element
+}
+
+type cacheEntry struct {
+	key  TileCoord
+	buff []byte
+}
+
+func newTileCache(limit int64) *tileCache {
+	return &tileCache{
+		limit: limit,
+		lru:   list.New(),
+		ents:  make(map[TileCoord]*list.Element),
+	}
+}
+
+func (c *tileCache) get(key TileCoord) []byte {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.ents[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).buff
+	}
+	return nil
+}
+
+func (c *tileCache) add(key TileCoord, buff []byte) {
+	sz := int64(len(buff))
+	if sz > c.limit {
+		return //also covers a disabled cache
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.ents[key]; ok {
+		ent := e.Value.(*cacheEntry)
+		c.size += sz - int64(len(ent.buff))
+		ent.buff = buff
+		c.lru.MoveToFront(e)
+	} else {
+		c.ents[key] = c.lru.PushFront(&cacheEntry{key: key, buff: buff})
+		c.size += sz
+	}
+	for c.size > c.limit {
+		ent := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.ents, ent.key)
+		c.size -= int64(len(ent.buff))
+	}
+}
+
+func (c *tileCache) purge() {
+	c.Lock()
+	defer c.Unlock()
+	c.lru.Init()
+	c.ents = make(map[TileCoord]*list.Element)
+	c.size = 0
+}
@@ -0,0 +1,337 @@
+package tilemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+var quadrantColors = [2][2]color.RGBA{
+	{{R: 255, A: 255}, {G: 255, A: 255}},
+	{{B: 255, A: 255}, {R: 255, G: 255, A: 255}},
+}
+
+// quadrantImage is a tile with a solid color in each quadrant, indexed [x][y]
+func quadrantImage(sz int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, sz, sz))
+	for y := 0; y < sz; y++ {
+		for x := 0; x < sz; x++ {
+			img.SetRGBA(x, y, quadrantColors[x*2/sz][y*2/sz])
+		}
+	}
+	return img
+}
+
+func near8(a, b uint8, d int) bool {
+	return abs(int(a)-int(b)) <= d
+}
+
+func TestOverzoomRaster(t *testing.T) {
+	var pb, jb bytes.Buffer
+	if err := png.Encode(&pb, quadrantImage(256)); err != nil {
+		t.Fatal(err)
+	} else if err = jpeg.Encode(&jb, quadrantImage(256), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		f    Format
+		buff []byte
+		diff int
+	}{
+		{FormatPNG, pb.Bytes(), 0},
+		{FormatJPEG, jb.Bytes(), 8},
+	} {
+		for sx := 0; sx < 2; sx++ {
+			for sy := 0; sy < 2; sy++ {
+				buff, err := OverzoomTile(tt.buff, tt.f, 1, sx, sy)
+				if err != nil {
+					t.Fatal(err)
+				} else if DetectFormat(buff) != tt.f {
+					t.Fatalf("overzoomed %v tile is %v", tt.f, DetectFormat(buff))
+				}
+				img, _, err := image.Decode(bytes.NewReader(buff))
+				if err != nil {
+					t.Fatal(err)
+				} else if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+					t.Fatalf("overzoomed tile is %v", b)
+				}
+				//the whole tile is the color of one quadrant, the far edges blend with the neighbours
+				want := quadrantColors[sx][sy]
+				for _, xy := range [][2]int{{128, 128}, {10, 10}, {245, 245}} {
+					r, g, b, _ := img.At(xy[0], xy[1]).RGBA()
+					if !near8(uint8(r>>8), want.R, tt.diff) || !near8(uint8(g>>8), want.G, tt.diff) || !near8(uint8(b>>8), want.B, tt.diff) {
+						t.Fatalf("%v %d %d pixel %v is %d %d %d not %v", tt.f, sx, sy, xy, r>>8, g>>8, b>>8, want)
+					}
+				}
+			}
+		}
+	}
+	//deeper zooms land inside a single quadrant
+	buff, err := OverzoomTile(pb.Bytes(), FormatPNG, 3, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(buff))
+	if err != nil {
+		t.Fatal(err)
+	} else if c := color.RGBAModel.Convert(img.At(0, 255)).(color.RGBA); c != quadrantColors[1][0] {
+		t.Fatalf("bad deep overzoom color %v", c)
+	}
+
+	if _, err = OverzoomTile(pb.Bytes(), FormatPNG, 1, 2, 0); err != ErrInvalidOverzoom {
+		t.Fatalf("Failed to catch descendant outside the tile: %v", err)
+	} else if _, err = OverzoomTile(testWebP, FormatWebP, 1, 0, 0); err != ErrOverzoomUnsupported {
+		t.Fatalf("Failed to refuse webp: %v", err)
+	} else if _, err = OverzoomTile([]byte(`garbage`), FormatPNG, 1, 0, 0); err == nil {
+		t.Fatal("Failed to catch a bad png")
+	} else if buff, err = OverzoomTile(pb.Bytes(), FormatPNG, 0, 0, 0); err != nil || !bytes.Equal(buff, pb.Bytes()) {
+		t.Fatalf("zero levels changed the tile %v", err)
+	}
+}
+
+// mvtGeometry encodes a geometry from commands of the form {id, count, x, y, x, y...}
+// with absolute coordinates
+func mvtGeometry(cmds ...[]int32) (b []byte) {
+	var cx, cy int32
+	for _, c := range cmds {
+		b = binary.AppendUvarint(b, uint64(uint32(c[0])|uint32(c[1])<<3))
+		for i := 2; i+1 < len(c); i += 2 {
+			b = binary.AppendUvarint(b, uint64(zigzag(c[i]-cx)))
+			b = binary.AppendUvarint(b, uint64(zigzag(c[i+1]-cy)))
+			cx, cy = c[i], c[i+1]
+		}
+	}
+	return
+}
+
+func mvtFeature(id uint64, typ uint64, geom []byte) (b []byte) {
+	b = binary.AppendUvarint(b, 1<<3|pbVarint)
+	b = binary.AppendUvarint(b, id)
+	b = binary.AppendUvarint(b, mvtFeatureType<<3|pbVarint)
+	b = binary.AppendUvarint(b, typ)
+	return appendBytesField(b, mvtFeatureGeom, geom)
+}
+
+func mvtLayer(name string, features ...[]byte) (b []byte) {
+	b = binary.AppendUvarint(b, 15<<3|pbVarint)
+	b = binary.AppendUvarint(b, 2)
+	b = appendBytesField(b, 1, []byte(name))
+	for _, f := range features {
+		b = appendBytesField(b, mvtLayerFeatures, f)
+	}
+	b = appendBytesField(b, 3, []byte(`key`))
+	b = binary.AppendUvarint(b, mvtLayerExtent<<3|pbVarint)
+	return binary.AppendUvarint(b, mvtDefaultExtent)
+}
+
+// readMVT returns the geometry of every feature by layer name and feature id
+func readMVT(t *testing.T, buff []byte) map[string]map[uint64][][]fpoint {
+	r := make(map[string]map[uint64][][]fpoint)
+	err := pbFields(buff, func(f pbField) error {
+		var name string
+		feats := make(map[uint64][][]fpoint)
+		err := pbFields(f.val, func(lf pbField) error {
+			if lf.num == 1 {
+				name = string(lf.val)
+			} else if lf.num == mvtLayerFeatures {
+				var id uint64
+				var geom [][]fpoint
+				err := pbFields(lf.val, func(ff pbField) (err error) {
+					if ff.num == 1 {
+						id = ff.v
+					} else if ff.num == mvtFeatureGeom {
+						geom, err = decodeGeometry(ff.val)
+					}
+					return
+				})
+				feats[id] = geom
+				return err
+			}
+			return nil
+		})
+		r[name] = feats
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestOverzoomMVT(t *testing.T) {
+	tile := appendBytesField(nil, mvtTileLayers, mvtLayer(`roads`,
+		mvtFeature(1, mvtPoint, mvtGeometry([]int32{mvtMoveTo, 2, 100, 100, 3000, 3000})),
+		mvtFeature(2, mvtLineString, mvtGeometry([]int32{mvtMoveTo, 1, 0, 0}, []int32{mvtLineTo, 1, 4096, 4096})),
+		mvtFeature(3, mvtPolygon, mvtGeometry(
+			[]int32{mvtMoveTo, 1, 1000, 1000},
+			[]int32{mvtLineTo, 3, 3000, 1000, 3000, 3000, 1000, 3000},
+			[]int32{mvtClosePath, 1})),
+	))
+	tile = appendBytesField(tile, mvtTileLayers, mvtLayer(`far`,
+		mvtFeature(4, mvtPoint, mvtGeometry([]int32{mvtMoveTo, 1, 4000, 100})),
+	))
+	if DetectFormat(tile) != FormatMVT {
+		t.Fatal("test tile does not look like a vector tile")
+	}
+
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write(tile)
+	gzw.Close()
+	for _, src := range [][]byte{tile, gz.Bytes()} {
+		buff, err := OverzoomTile(src, FormatMVT, 1, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(src, gzipMagic) {
+			gzr, err := gzip.NewReader(bytes.NewReader(buff))
+			if err != nil {
+				t.Fatal(err)
+			} else if buff, err = io.ReadAll(gzr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		layers := readMVT(t, buff)
+		if _, ok := layers[`far`]; ok || len(layers) != 1 {
+			t.Fatalf("empty layer was not dropped %v", layers)
+		}
+		roads := layers[`roads`]
+		//the window is 0-2048 plus a 32 unit buffer, everything is doubled into the new tile
+		edge := float64(2 * (2048 + mvtDefaultExtent/mvtBufferDiv/2))
+		expected := map[uint64][][]fpoint{
+			1: {{{200, 200}}},
+			2: {{{0, 0}, {edge, edge}}},
+			3: {{{2000, edge}, {2000, 2000}, {edge, 2000}, {edge, edge}}},
+		}
+		if len(roads) != len(expected) {
+			t.Fatalf("bad features %v", roads)
+		}
+		for id, want := range expected {
+			got := roads[id]
+			if len(got) != len(want) {
+				t.Fatalf("feature %d has %v not %v", id, got, want)
+			}
+			for i := range want {
+				if len(got[i]) != len(want[i]) {
+					t.Fatalf("feature %d has %v not %v", id, got, want)
+				}
+				for j := range want[i] {
+					if got[i][j] != want[i][j] {
+						t.Fatalf("feature %d has %v not %v", id, got, want)
+					}
+				}
+			}
+		}
+	}
+
+	//a line that passes through the window is cut into the piece inside it
+	c := mvtClip{minX: 0, minY: 0, maxX: 10, maxY: 10, scale: 1}
+	lines := c.clipLine([]fpoint{{-5, 5}, {5, 5}, {5, 20}, {20, 20}, {20, 5}, {8, 5}})
+	if len(lines) != 2 || len(lines[0]) != 3 || lines[0][0] != (fpoint{0, 5}) || lines[0][2] != (fpoint{5, 10}) ||
+		len(lines[1]) != 2 || lines[1][0] != (fpoint{10, 5}) || lines[1][1] != (fpoint{8, 5}) {
+		t.Fatalf("bad clipped lines %v", lines)
+	}
+	if _, err := OverzoomTile([]byte{0x1a, 0xff}, FormatMVT, 1, 0, 0); err != ErrInvalidMVT {
+		t.Fatalf("Failed to catch bad vector tile: %v", err)
+	}
+
+	//a gzip bomb is refused rather than inflated into memory
+	gz.Reset()
+	gzw = gzip.NewWriter(&gz)
+	gzw.Write(make([]byte, maxTileSize+1))
+	gzw.Close()
+	if _, err := OverzoomTile(gz.Bytes(), FormatMVT, 1, 0, 0); err != ErrInvalidTileBuffer {
+		t.Fatalf("Failed to catch oversized gzipped tile: %v", err)
+	}
+}
+
+func TestOverzoomer(t *testing.T) {
+	ts, err := OpenTilesetConfig(filepath.Join(tdir, `overzoom`), Config{Format: FormatPNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pb bytes.Buffer
+	if err = png.Encode(&pb, quadrantImage(64)); err != nil {
+		t.Fatal(err)
+	}
+	//zoom 2 is only partially populated, zoom 1 covers the rest
+	if err = ts.Add(1, 0, 0, pb.Bytes()); err != nil {
+		t.Fatal(err)
+	} else if err = ts.Add(1, 1, 1, pb.Bytes()); err != nil {
+		t.Fatal(err)
+	} else if err = ts.Add(2, 3, 3, pb.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	oz := NewOverzoomer(ts, OverzoomConfig{MaxLevels: 3})
+	if oz.Source(5) != 2 || oz.Source(1) != 1 {
+		t.Fatal("bad source zoom")
+	}
+	if buff, err := oz.GetTile(1, 0, 0); err != nil || !bytes.Equal(buff, pb.Bytes()) {
+		t.Fatalf("stored tile changed %v", err)
+	} else if _, err = oz.GetTile(2, 0, 0); err != ErrTileNotFound {
+		t.Fatalf("stored zooms are not overzoomed: %v", err)
+	}
+	//zoom 3 tile 6,6 is inside 2/3/3, 0,1 falls back to 1/0/0
+	a, err := oz.GetTile(3, 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	} else if b, err := oz.GetTile(3, 6, 6); err != nil || &a[0] != &b[0] {
+		t.Fatalf("synthesized tile was not cached %v", err)
+	}
+	if buff, err := oz.GetTile(3, 0, 1); err != nil {
+		t.Fatal(err)
+	} else if img, err := png.Decode(bytes.NewReader(buff)); err != nil {
+		t.Fatal(err)
+	} else if c := color.RGBAModel.Convert(img.At(32, 32)).(color.RGBA); c != quadrantColors[0][0] {
+		t.Fatalf("bad overzoom from a lower ancestor %v", c)
+	}
+	if _, err = oz.GetTile(3, 7, 0); err != ErrTileNotFound {
+		t.Fatalf("tile without an ancestor: %v", err)
+	} else if _, err = oz.GetTile(6, 0, 0); err != ErrTileNotFound {
+		t.Fatalf("overzoomed past the level limit: %v", err)
+	} else if _, err = oz.GetTile(3, 8, 0); err != ErrInvalidTileID {
+		t.Fatalf("Failed to catch tile outside the map: %v", err)
+	}
+	oz.Purge()
+	if b, err := oz.GetTile(3, 6, 6); err != nil || &a[0] == &b[0] {
+		t.Fatalf("purge kept the cached tile %v", err)
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTileCache(t *testing.T) {
+	c := newTileCache(10)
+	for i := 0; i < 3; i++ {
+		c.add(TileCoord{Zoom: 5, X: i}, []byte(`abc`))
+	}
+	if c.size != 9 || c.get(TileCoord{Zoom: 5}) == nil {
+		t.Fatalf("bad cache size %d", c.size)
+	}
+	//the first tile was just used, the second is the oldest
+	c.add(TileCoord{Zoom: 5, X: 9}, []byte(`abc`))
+	if c.get(TileCoord{Zoom: 5, X: 1}) != nil || c.get(TileCoord{Zoom: 5}) == nil || c.size != 9 {
+		t.Fatalf("bad eviction %d", c.size)
+	}
+	c.add(TileCoord{Zoom: 5, X: 10}, []byte(`too large for the cache`))
+	if c.get(TileCoord{Zoom: 5, X: 10}) != nil {
+		t.Fatal("cached a tile larger than the cache")
+	}
+	c.purge()
+	if c.size != 0 || c.get(TileCoord{Zoom: 5}) != nil {
+		t.Fatal("purge left tiles behind")
+	}
+	d := newTileCache(-1)
+	d.add(TileCoord{}, []byte(`x`))
+	if d.get(TileCoord{}) != nil {
+		t.Fatal("disabled cache kept a tile")
+	}
+}
@@ -0,0 +1,504 @@
+package tilemap
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	patchMagic      = "GWTILPCH"
+	patchVersion    = 1
+	patchHeaderSize = 128
+
+	patchBlob   = 'B' //uint32 size followed by the tile
+	patchPut    = 'P' //uint32 x, uint32 y, uint32 blob number
+	patchDelete = 'D' //uint32 x, uint32 y
+)
+
+var (
+	ErrInvalidPatch  = errors.New("invalid tilemap patch, file may be corrupt")
+	ErrPatchBase     = errors.New("tilemap does not match the base of the patch")
+	ErrPatchChecksum = errors.New("patched tilemap does not match the patch checksum")
+)
+
+// ContentSum is a SHA-256 over the tiles of a tilemap, see ContentChecksum
+type ContentSum [sha256.Size]byte
+
+func (s ContentSum) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// PatchStats describes a patch written by Diff
+type PatchStats struct {
+	Zoom    int
+	Puts    int64      //tiles added or changed
+	Deletes int64      //tiles removed
+	Blobs   int64      //distinct tiles carried by the patch
+	Bytes   int64      //size of the patch file
+	Base    ContentSum //content checksum of the tilemap the patch applies to
+	Result  ContentSum //content checksum of the tilemap after the patch is applied
+}
+
+// patch layout, all values are little endian
+// [0:8]    magic
+// [8:10]   version
+// [10]     zoom
+// [16:24]  put count
+// [24:32]  delete count
+// [32:40]  blob count
+// [40:72]  base content checksum
+// [72:104] result content checksum
+// [104:128] reserved
+// [128:]   records, a one byte type followed by its fields, blobs come before the puts that use them
+
+func (ps *PatchStats) encode(b []byte) {
+	copy(b, patchMagic)
+	binary.LittleEndian.PutUint16(b[8:], patchVersion)
+	b[10] = uint8(ps.Zoom)
+	binary.LittleEndian.PutUint64(b[16:], uint64(ps.Puts))
+	binary.LittleEndian.PutUint64(b[24:], uint64(ps.Deletes))
+	binary.LittleEndian.PutUint64(b[32:], uint64(ps.Blobs))
+	copy(b[40:72], ps.Base[:])
+	copy(b[72:104], ps.Result[:])
+}
+
+func (ps *PatchStats) decode(b []byte) (err error) {
+	if len(b) < patchHeaderSize || string(b[:len(patchMagic)]) != patchMagic {
+		err = ErrInvalidPatch
+		return
+	} else if binary.LittleEndian.Uint16(b[8:]) != patchVersion {
+		err = ErrUnsupportedVersion
+		return
+	}
+	ps.Zoom = int(b[10])
+	ps.Puts = int64(binary.LittleEndian.Uint64(b[16:]))
+	ps.Deletes = int64(binary.LittleEndian.Uint64(b[24:]))
+	ps.Blobs = int64(binary.LittleEndian.Uint64(b[32:]))
+	copy(ps.Base[:], b[40:72])
+	copy(ps.Result[:], b[72:104])
+	if ps.Zoom > MaxZoom || ps.Puts < 0 || ps.Deletes < 0 || ps.Blobs < 0 || ps.Blobs > ps.Puts {
+		err = ErrInvalidPatch
+	}
+	return
+}
+
+// ReadPatchHeader reads the description of a patch file without applying it
+func ReadPatchHeader(pth string) (ps PatchStats, err error) {
+	var fin *os.File
+	if fin, ps, err = openPatch(pth); err == nil {
+		err = fin.Close()
+	}
+	return
+}
+
+func openPatch(pth string) (fin *os.File, ps PatchStats, err error) {
+	if fin, err = os.Open(pth); err != nil {
+		return
+	}
+	var fi os.FileInfo
+	buff := make([]byte, patchHeaderSize)
+	if fi, err = fin.Stat(); err == nil {
+		if _, err = fin.ReadAt(buff, 0); err == io.EOF {
+			err = ErrInvalidPatch
+		} else if err == nil {
+			err = ps.decode(buff)
+		}
+	}
+	if err != nil {
+		fin.Close()
+		fin = nil
+		return
+	}
+	ps.Bytes = fi.Size()
+	return
+}
+
+// tileBlob is a populated tile and the blob it references
+type tileBlob struct {
+	x, y int
+	dp   datapointer
+}
+
+// contents snapshots every populated tile in row major order along with a digest of each blob
+func (w *Tilemap) contents() (ents []tileBlob, digs map[int64]ContentSum, err error) {
+	w.RLock()
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		if w.published(dp) {
+			x, y := w.tilexy(tid)
+			ents = append(ents, tileBlob{x: x, y: y, dp: dp})
+		}
+		return nil
+	})
+	w.RUnlock()
+	if err != nil {
+		return
+	}
+	blobs := make([]datapointer, 0, len(ents))
+	digs = make(map[int64]ContentSum, len(ents))
+	for _, e := range ents {
+		if _, ok := digs[e.dp.offset]; !ok {
+			digs[e.dp.offset] = ContentSum{}
+			blobs = append(blobs, e.dp)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].offset < blobs[j].offset
+	})
+	for _, dp := range blobs {
+		var buff []byte
+		if buff, err = w.readBlob(dp); err != nil {
+			return
+		}
+		digs[dp.offset] = sha256.Sum256(buff)
+	}
+	sort.Slice(ents, func(i, j int) bool {
+		return tileBlobLess(ents[i], ents[j])
+	})
+	return
+}
+
+// readBlob reads and decodes the blob at dp
+func (w *Tilemap) readBlob(dp datapointer) (buff []byte, err error) {
+	w.RLock()
+	defer w.RUnlock()
+	if buff, err = w.readStored(dp); err == nil {
+		buff, err = w.decode(buff)
+	}
+	return
+}
+
+func tileBlobLess(a, b tileBlob) bool {
+	return a.x < b.x || (a.x == b.x && a.y < b.y)
+}
+
+func contentSum(zoom int, ents []tileBlob, digs map[int64]ContentSum) (sum ContentSum) {
+	var b [8]byte
+	h := sha256.New()
+	h.Write([]byte{uint8(zoom)})
+	for _, e := range ents {
+		binary.LittleEndian.PutUint32(b[:], uint32(e.x))
+		binary.LittleEndian.PutUint32(b[4:], uint32(e.y))
+		d := digs[e.dp.offset]
+		h.Write(b[:])
+		h.Write(d[:])
+	}
+	copy(sum[:], h.Sum(nil))
+	return
+}
+
+// ContentChecksum returns a SHA-256 over the zoom and every decoded tile with its coordinates.
+// Tilemaps holding the same tiles have the same checksum regardless of layout.
+func (w *Tilemap) ContentChecksum() (sum ContentSum, err error) {
+	var ents []tileBlob
+	var digs map[int64]ContentSum
+	if ents, digs, err = w.contents(); err == nil {
+		sum = contentSum(w.zoom, ents, digs)
+	}
+	return
+}
+
+// Diff writes a patch to pth that turns old into nw, carrying added and changed tiles and deletes
+func Diff(old, nw *Tilemap, pth string) (ps PatchStats, err error) {
+	if old.Zoom() != nw.Zoom() {
+		err = ErrZoomMismatch
+		return
+	} else if err = ensureAbsent(pth); err != nil {
+		return
+	}
+	var oents, nents []tileBlob
+	var odigs, ndigs map[int64]ContentSum
+	if oents, odigs, err = old.contents(); err != nil {
+		return
+	} else if nents, ndigs, err = nw.contents(); err != nil {
+		return
+	}
+	ps.Zoom = nw.Zoom()
+	ps.Base = contentSum(ps.Zoom, oents, odigs)
+	ps.Result = contentSum(ps.Zoom, nents, ndigs)
+
+	var fout *os.File
+	if fout, err = os.Create(pth); err != nil {
+		return
+	}
+	pw := patchWriter{Writer: bufio.NewWriter(fout), ids: make(map[ContentSum]uint32)}
+	pw.Write(make([]byte, patchHeaderSize))
+	put := func(e tileBlob) error {
+		d := ndigs[e.dp.offset]
+		id, ok := pw.ids[d]
+		if !ok {
+			buff, lerr := nw.readBlob(e.dp)
+			if lerr != nil {
+				return lerr
+			}
+			id = pw.blob(d, buff)
+			ps.Blobs++
+		}
+		pw.tile(patchPut, e.x, e.y, &id)
+		ps.Puts++
+		return nil
+	}
+	//both sides are in row major order, walk them together
+	for i, j := 0, 0; err == nil && (i < len(oents) || j < len(nents)); {
+		switch {
+		case j == len(nents) || (i < len(oents) && tileBlobLess(oents[i], nents[j])):
+			pw.tile(patchDelete, oents[i].x, oents[i].y, nil)
+			ps.Deletes++
+			i++
+		case i == len(oents) || tileBlobLess(nents[j], oents[i]):
+			err = put(nents[j])
+			j++
+		default:
+			if odigs[oents[i].dp.offset] != ndigs[nents[j].dp.offset] {
+				err = put(nents[j])
+			}
+			i++
+			j++
+		}
+	}
+	if err == nil {
+		err = pw.Flush()
+	}
+	if err == nil {
+		buff := make([]byte, patchHeaderSize)
+		ps.encode(buff)
+		ps.Bytes = pw.n
+		_, err = fout.WriteAt(buff, 0)
+	}
+	if err != nil {
+		fout.Close()
+		os.Remove(pth)
+	} else if err = fout.Close(); err != nil {
+		os.Remove(pth)
+	}
+	return
+}
+
+// patchWriter writes patch records, write errors are sticky in the bufio.Writer and show up on Flush
+type patchWriter struct {
+	*bufio.Writer
+	ids map[ContentSum]uint32
+	n   int64
+	rec [13]byte
+}
+
+func (pw *patchWriter) Write(b []byte) (n int, err error) {
+	n, err = pw.Writer.Write(b)
+	pw.n += int64(n)
+	return
+}
+
+// blob writes a blob record and returns its number
+func (pw *patchWriter) blob(d ContentSum, buff []byte) (id uint32) {
+	id = uint32(len(pw.ids))
+	pw.ids[d] = id
+	pw.rec[0] = patchBlob
+	binary.LittleEndian.PutUint32(pw.rec[1:], uint32(len(buff)))
+	pw.Write(pw.rec[:5])
+	pw.Write(buff)
+	return
+}
+
+// tile writes a put or delete record, deletes do not reference a blob
+func (pw *patchWriter) tile(typ byte, x, y int, id *uint32) {
+	pw.rec[0] = typ
+	binary.LittleEndian.PutUint32(pw.rec[1:], uint32(x))
+	binary.LittleEndian.PutUint32(pw.rec[5:], uint32(y))
+	if id == nil {
+		pw.Write(pw.rec[:9])
+		return
+	}
+	binary.LittleEndian.PutUint32(pw.rec[9:], *id)
+	pw.Write(pw.rec[:13])
+}
+
+// ApplyPatch applies a patch written by Diff to the tilemap in place.
+// ErrPatchChecksum means the tilemap was changed but does not hold the expected tiles.
+func (w *Tilemap) ApplyPatch(pth string) (err error) {
+	if w.ro {
+		err = ErrReadOnly
+		return
+	}
+	var fin *os.File
+	var ps PatchStats
+	if fin, ps, err = openPatch(pth); err != nil {
+		return
+	}
+	defer fin.Close()
+	if err = checkPatchBase(w, ps); err == nil {
+		err = w.applyPatch(fin, ps)
+	}
+	return
+}
+
+// ApplyPatchTo writes src with the patch applied to out, leaving src as it is
+func ApplyPatchTo(src *Tilemap, pth, out string) (err error) {
+	var fin *os.File
+	var ps PatchStats
+	if fin, ps, err = openPatch(pth); err != nil {
+		return
+	}
+	defer fin.Close()
+	if err = checkPatchBase(src, ps); err != nil {
+		return
+	}
+	hdr := src.Header()
+	c := Config{
+		Index:       hdr.Index,
+		Checksums:   hdr.Checksums,
+		Compression: hdr.Compression,
+		Curve:       hdr.Curve,
+	}
+	if err = Rewrite(src, out, c); err != nil {
+		return
+	}
+	var dst *Tilemap
+	if dst, err = NewTilemapConfig(out, Config{Zoom: AnyZoom}); err != nil {
+		os.Remove(out)
+		return
+	}
+	if err = dst.applyPatch(fin, ps); err != nil {
+		dst.Close()
+		os.Remove(out)
+		return
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(out)
+	}
+	return
+}
+
+func checkPatchBase(w *Tilemap, ps PatchStats) (err error) {
+	var sum ContentSum
+	if w.Zoom() != ps.Zoom {
+		err = ErrZoomMismatch
+	} else if sum, err = w.ContentChecksum(); err == nil && sum != ps.Base {
+		err = ErrPatchBase
+	}
+	return
+}
+
+// applyPatch checks every record before writing any of them and verifies the result
+func (w *Tilemap) applyPatch(fin *os.File, ps PatchStats) (err error) {
+	if err = walkPatch(fin, ps, nil); err != nil {
+		return
+	}
+	batch := make([]Tile, 0, rewriteBatch)
+	if err = walkPatch(fin, ps, func(x, y int, buff []byte) (err error) {
+		if buff == nil {
+			if err = w.Delete(x, y); err != nil {
+				err = fmt.Errorf("Failed to delete %d/%d: %v", x, y, err)
+			}
+		} else if batch = append(batch, Tile{X: x, Y: y, Data: buff}); len(batch) == rewriteBatch {
+			err = w.AddBatch(batch)
+			batch = batch[:0]
+		}
+		return
+	}); err != nil {
+		return
+	}
+	if len(batch) > 0 {
+		if err = w.AddBatch(batch); err != nil {
+			return
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	var sum ContentSum
+	if sum, err = w.ContentChecksum(); err == nil && sum != ps.Result {
+		err = ErrPatchChecksum
+	}
+	return
+}
+
+// walkPatch calls fn for every put and delete in a patch, deletes have a nil buffer and a nil fn only checks the patch
+func walkPatch(fin *os.File, ps PatchStats, fn func(x, y int, buff []byte) error) (err error) {
+	type blobRef struct {
+		off  int64
+		size int
+	}
+	var blobs []blobRef
+	var puts, deletes int64
+	var rec [12]byte
+	off := int64(patchHeaderSize)
+	br := bufio.NewReader(io.NewSectionReader(fin, off, ps.Bytes-off))
+	read := func(b []byte) (err error) {
+		if _, err = io.ReadFull(br, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrInvalidPatch
+		}
+		off += int64(len(b))
+		return
+	}
+	for {
+		var typ byte
+		if typ, err = br.ReadByte(); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+		off++
+		switch typ {
+		case patchBlob:
+			if err = read(rec[:4]); err != nil {
+				return
+			}
+			sz := int(binary.LittleEndian.Uint32(rec[:]))
+			if sz == 0 || sz > maxTileSize {
+				err = ErrInvalidPatch
+				return
+			}
+			blobs = append(blobs, blobRef{off: off, size: sz})
+			if _, err = br.Discard(sz); err != nil {
+				err = ErrInvalidPatch
+				return
+			}
+			off += int64(sz)
+		case patchPut:
+			if err = read(rec[:12]); err != nil {
+				return
+			}
+			x, y := int(binary.LittleEndian.Uint32(rec[:])), int(binary.LittleEndian.Uint32(rec[4:]))
+			id := binary.LittleEndian.Uint32(rec[8:])
+			if int(id) >= len(blobs) {
+				err = ErrInvalidPatch
+				return
+			}
+			puts++
+			if fn == nil {
+				continue
+			}
+			buff := make([]byte, blobs[id].size)
+			if _, err = fin.ReadAt(buff, blobs[id].off); err != nil {
+				return
+			} else if err = fn(x, y, buff); err != nil {
+				return
+			}
+		case patchDelete:
+			if err = read(rec[:8]); err != nil {
+				return
+			}
+			deletes++
+			if fn != nil {
+				x, y := int(binary.LittleEndian.Uint32(rec[:])), int(binary.LittleEndian.Uint32(rec[4:]))
+				if err = fn(x, y, nil); err != nil {
+					return
+				}
+			}
+		default:
+			err = ErrInvalidPatch
+			return
+		}
+	}
+	if puts != ps.Puts || deletes != ps.Deletes || int64(len(blobs)) != ps.Blobs {
+		err = ErrInvalidPatch
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package tilemap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	opth := filepath.Join(tdir, `patchold`)
+	old, err := NewTilemapConfig(opth, Config{Zoom: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			if err = old.Add(x, y, []byte(fmt.Sprintf("tile %d %d", x, y%2))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	oldSum, err := old.ContentChecksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the same tiles in a different layout have the same checksum
+	npth := filepath.Join(tdir, `patchnew`)
+	if err = Rewrite(old, npth, Config{Curve: CurveHilbert, Compression: CompressGzip}); err != nil {
+		t.Fatal(err)
+	}
+	nw, err := NewTilemapConfig(npth, Config{Zoom: AnyZoom})
+	if err != nil {
+		t.Fatal(err)
+	} else if sum, err := nw.ContentChecksum(); err != nil {
+		t.Fatal(err)
+	} else if sum != oldSum {
+		t.Fatalf("layout changed the checksum %v != %v", sum, oldSum)
+	}
+
+	//change two tiles, add two that share a blob, and delete one
+	if err = nw.AddBatch([]Tile{
+		{X: 0, Y: 0, Data: []byte(`changed`)},
+		{X: 1, Y: 1, Data: []byte(`tile 0 1`)}, //an existing blob, still carried by the patch
+		{X: 5, Y: 6, Data: []byte(`new`)},
+		{X: 6, Y: 6, Data: []byte(`new`)},
+	}); err != nil {
+		t.Fatal(err)
+	} else if err = nw.Delete(7, 3); err != nil {
+		t.Fatal(err)
+	}
+	newSum, err := nw.ContentChecksum()
+	if err != nil {
+		t.Fatal(err)
+	} else if newSum == oldSum {
+		t.Fatal("checksum did not change")
+	}
+
+	ppth := filepath.Join(tdir, `patch`)
+	ps, err := Diff(old, nw, ppth)
+	if err != nil {
+		t.Fatal(err)
+	} else if ps.Puts != 4 || ps.Deletes != 1 || ps.Blobs != 3 || ps.Base != oldSum || ps.Result != newSum {
+		t.Fatalf("bad patch %+v", ps)
+	} else if _, err = Diff(old, nw, ppth); err == nil {
+		t.Fatal("Failed to catch existing patch")
+	} else if hps, err := ReadPatchHeader(ppth); err != nil {
+		t.Fatal(err)
+	} else if hps != ps {
+		t.Fatalf("bad patch header %+v != %+v", hps, ps)
+	}
+
+	//a new generation leaves the source alone
+	gpth := filepath.Join(tdir, `patchgen`)
+	if err = ApplyPatchTo(nw, ppth, gpth); err != ErrPatchBase {
+		t.Fatalf("Failed to catch wrong base: %v", err)
+	} else if err = ApplyPatchTo(old, ppth, gpth); err != nil {
+		t.Fatal(err)
+	} else if sum, err := old.ContentChecksum(); err != nil || sum != oldSum {
+		t.Fatalf("source was modified %v", err)
+	}
+	gen, err := OpenTilemap(gpth, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if sum, err := gen.ContentChecksum(); err != nil || sum != newSum {
+		t.Fatalf("bad new generation %v", err)
+	} else if buff, err := gen.GetTile(6, 6); err != nil || string(buff) != `new` {
+		t.Fatalf("bad patched tile %q %v", buff, err)
+	} else if gen.Has(7, 3) {
+		t.Fatal("deleted tile is still present")
+	} else if err = gen.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a corrupt patch is caught before anything is written
+	buff, err := os.ReadFile(ppth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bpth := filepath.Join(tdir, `patchbad`)
+	if err = os.WriteFile(bpth, buff[:len(buff)-3], 0640); err != nil {
+		t.Fatal(err)
+	} else if err = old.ApplyPatch(bpth); err != ErrInvalidPatch {
+		t.Fatalf("Failed to catch truncated patch: %v", err)
+	} else if sum, err := old.ContentChecksum(); err != nil || sum != oldSum {
+		t.Fatalf("truncated patch modified the tilemap %v", err)
+	}
+
+	//patch in place, the patch no longer applies afterwards
+	if err = old.ApplyPatch(ppth); err != nil {
+		t.Fatal(err)
+	} else if err = old.ApplyPatch(ppth); err != ErrPatchBase {
+		t.Fatalf("Failed to catch reapplied patch: %v", err)
+	} else if err = old.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if old, err = OpenTilemap(opth, true); err != nil {
+		t.Fatal(err)
+	} else if sum, err := old.ContentChecksum(); err != nil || sum != newSum {
+		t.Fatalf("bad patched tilemap %v", err)
+	} else if err = old.ApplyPatch(ppth); err != ErrReadOnly {
+		t.Fatalf("Failed to catch read only tilemap: %v", err)
+	}
+	for _, tm := range []*Tilemap{old, nw} {
+		if err = tm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
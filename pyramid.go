@@ -0,0 +1,271 @@
+package tilemap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+const (
+	pyramidBatch     = 256  //parent tiles handed to AddBatch at a time
+	pyramidMemoLimit = 1024 //distinct uniform quads remembered per level
+)
+
+var (
+	ErrDownsampleUnsupported = errors.New("tile format cannot be downsampled")
+	ErrTileSizeMismatch      = errors.New("child tiles are not the same size")
+)
+
+// PyramidConfig controls how lower zoom levels are generated
+type PyramidConfig struct {
+	Workers int //tiles composited in parallel, defaults to the number of CPUs
+	MinZoom int //lowest zoom level to generate, defaults to 0
+	//Background fills the quadrants of parents whose child tile is missing.  The zero value is
+	//transparent for PNG and opaque white for JPEG, which cannot store transparency.
+	Background color.RGBA
+}
+
+var jpegBackground = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+// BuildPyramid generates every zoom level from zoom-1 down to c.MinZoom by downsampling the level above it.
+// Existing tiles at those levels are replaced.
+func BuildPyramid(ts *Tileset, zoom int, c PyramidConfig) (err error) {
+	src := ts.Tilemap(zoom)
+	if src == nil {
+		err = fmt.Errorf("zoom level %d is not in the tileset", zoom)
+		return
+	} else if c.MinZoom < 0 || c.MinZoom > zoom {
+		err = ErrInvalidDimension
+		return
+	}
+	hdr := src.Header()
+	for z := zoom - 1; z >= c.MinZoom; z-- {
+		var dst *Tilemap
+		if dst, err = ts.writerWith(z, func(tc *Config) {
+			if tc.MimeType == `` && tc.Format == FormatUnknown {
+				tc.MimeType, tc.Format = hdr.MimeType, hdr.Format
+			}
+		}); err != nil {
+			return
+		}
+		if err = Downsample(src, dst, c); err != nil {
+			err = fmt.Errorf("Failed to build zoom %d: %v", z, err)
+			return
+		}
+		src = dst
+	}
+	return
+}
+
+// Downsample composites each 2x2 block of tiles in src into a tile half the size in dst, one zoom level below.
+// Missing children are filled with c.Background.
+func Downsample(src, dst *Tilemap, c PyramidConfig) (err error) {
+	f := src.Format()
+	if f != FormatPNG && f != FormatJPEG {
+		err = ErrDownsampleUnsupported
+		return
+	} else if dst.Zoom() != src.Zoom()-1 {
+		err = ErrInvalidDimension
+		return
+	}
+	if c.Workers <= 0 {
+		c.Workers = runtime.NumCPU()
+	}
+	if f == FormatJPEG && c.Background == (color.RGBA{}) {
+		c.Background = jpegBackground
+	}
+
+	//collect every parent that has at least one child
+	parents := map[TileCoord]es{}
+	if err = src.Walk(func(x, y int, _ int64) error {
+		parents[TileCoord{Zoom: dst.Zoom(), X: x >> 1, Y: y >> 1}] = es{}
+		return nil
+	}); err != nil {
+		return
+	}
+	todo := make([]TileCoord, 0, len(parents))
+	for p := range parents {
+		todo = append(todo, p)
+	}
+	parents = nil
+	sort.Slice(todo, func(i, j int) bool {
+		return dst.tileid(todo[i].X, todo[i].Y) < dst.tileid(todo[j].X, todo[j].Y)
+	})
+
+	d := downsampler{
+		src:  src,
+		f:    f,
+		bg:   c.Background,
+		memo: make(map[uint64]quadMemo),
+	}
+	reqs := make(chan TileCoord, c.Workers)
+	res := make(chan Tile, c.Workers)
+	done := make(chan es)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var werr error
+	fail := func(lerr error) {
+		errOnce.Do(func() {
+			werr = lerr
+			close(done)
+		})
+	}
+	for i := 0; i < c.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range reqs {
+				buff, lerr := d.parent(p.X, p.Y)
+				if lerr != nil {
+					fail(fmt.Errorf("%d/%d/%d: %v", p.Zoom, p.X, p.Y, lerr))
+					return
+				}
+				select {
+				case res <- Tile{X: p.X, Y: p.Y, Data: buff}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(reqs)
+		for _, p := range todo {
+			select {
+			case reqs <- p:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(res)
+	}()
+
+	batch := make([]Tile, 0, pyramidBatch)
+	for t := range res {
+		select {
+		case <-done:
+			continue //drain
+		default:
+		}
+		if batch = append(batch, t); len(batch) == pyramidBatch {
+			if lerr := dst.AddBatch(batch); lerr != nil {
+				fail(lerr)
+			}
+			batch = batch[:0]
+		}
+	}
+	//every worker has exited so werr is settled
+	if err = werr; err != nil {
+		return
+	} else if len(batch) > 0 {
+		if err = dst.AddBatch(batch); err != nil {
+			return
+		}
+	}
+	err = dst.Flush()
+	return
+}
+
+type downsampler struct {
+	sync.Mutex
+	src  *Tilemap
+	f    Format
+	bg   color.RGBA
+	memo map[uint64]quadMemo //keyed by the hash of the child in a uniform quad
+}
+
+type quadMemo struct {
+	child  []byte
+	parent []byte
+}
+
+// parent builds the tile at x, y from its four children in src
+func (d *downsampler) parent(x, y int) (r []byte, err error) {
+	var kids [4][]byte
+	for i := range kids {
+		if kids[i], err = d.src.GetTile(2*x+(i&1), 2*y+(i>>1)); err == ErrTileNotFound {
+			err = nil
+		} else if err != nil {
+			return
+		}
+	}
+	uniform := kids[0] != nil
+	for _, k := range kids[1:] {
+		uniform = uniform && bytes.Equal(k, kids[0])
+	}
+	var key uint64
+	if uniform {
+		key = sipHash(kids[0])
+		d.Lock()
+		m, ok := d.memo[key]
+		d.Unlock()
+		if ok && bytes.Equal(m.child, kids[0]) {
+			r = m.parent
+			return
+		}
+	}
+	if r, err = downsampleQuad(kids, d.f, d.bg); err == nil && uniform {
+		d.Lock()
+		if len(d.memo) < pyramidMemoLimit {
+			d.memo[key] = quadMemo{child: kids[0], parent: r}
+		}
+		d.Unlock()
+	}
+	return
+}
+
+// downsampleQuad composites four children ordered NW, NE, SW, SE into a single tile of the same
+// size using a 2x2 box filter, the quadrants of nil children are filled with bg
+func downsampleQuad(kids [4][]byte, f Format, bg color.RGBA) (r []byte, err error) {
+	var imgs [4]*image.RGBA
+	var w, h int
+	for i, k := range kids {
+		if k == nil {
+			continue
+		}
+		if imgs[i], err = decodeRaster(k, f); err != nil {
+			return
+		}
+		if kw, kh := imgs[i].Rect.Dx(), imgs[i].Rect.Dy(); w == 0 {
+			w, h = kw, kh
+		} else if kw != w || kh != h {
+			err = ErrTileSizeMismatch
+			return
+		}
+	}
+	if w == 0 {
+		err = ErrTileNotFound
+		return
+	}
+	//the RGBA pixels are premultiplied so averaging them is correct for partially transparent pixels
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			sx, sy := 2*px, 2*py
+			kid := imgs[sx/w+2*(sy/h)]
+			out := dst.Pix[dst.PixOffset(px, py):]
+			if kid == nil {
+				out[0], out[1], out[2], out[3] = bg.R, bg.G, bg.B, bg.A
+				continue
+			}
+			sx, sy = sx%w, sy%h
+			p0 := kid.Pix[kid.PixOffset(sx, sy):]
+			p1 := kid.Pix[kid.PixOffset(min(sx+1, w-1), sy):]
+			p2 := kid.Pix[kid.PixOffset(sx, min(sy+1, h-1)):]
+			p3 := kid.Pix[kid.PixOffset(min(sx+1, w-1), min(sy+1, h-1)):]
+			for i := 0; i < 4; i++ {
+				out[i] = uint8((int(p0[i]) + int(p1[i]) + int(p2[i]) + int(p3[i]) + 2) / 4)
+			}
+		}
+	}
+	r, err = encodeRaster(dst, f)
+	return
+}
@@ -0,0 +1,160 @@
+package tilemap
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+func solidPNG(t *testing.T, sz int, c color.RGBA) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, sz, sz))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	var bb bytes.Buffer
+	if err := png.Encode(&bb, img); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func TestBuildPyramid(t *testing.T) {
+	const sz = 8
+	red := color.RGBA{R: 255, A: 255}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	ts, err := OpenTileset(filepath.Join(tdir, `pyramid`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a red tile in the north west corner and a blank east half
+	var bb bytes.Buffer
+	if err = png.Encode(&bb, quadrantImage(sz)); err != nil {
+		t.Fatal(err)
+	}
+	tiles := []Tile{{X: 0, Y: 0, Data: solidPNG(t, sz, red)}, {X: 1, Y: 1, Data: bb.Bytes()}}
+	for x := 2; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			tiles = append(tiles, Tile{X: x, Y: y, Data: solidPNG(t, sz, white)})
+		}
+	}
+	if err = ts.AddBatch(2, tiles); err != nil {
+		t.Fatal(err)
+	} else if err = ts.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = BuildPyramid(ts, 2, PyramidConfig{Workers: 3, MinZoom: 3}); err != ErrInvalidDimension {
+		t.Fatalf("Failed to catch bad min zoom: %v", err)
+	} else if err = BuildPyramid(ts, 3, PyramidConfig{}); err == nil {
+		t.Fatal("Failed to catch missing zoom")
+	} else if err = BuildPyramid(ts, 2, PyramidConfig{Workers: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if zooms := ts.Zooms(); len(zooms) != 3 {
+		t.Fatalf("bad zooms %v", zooms)
+	} else if ts.Format(0) != FormatPNG {
+		t.Fatalf("generated level did not sniff as png: %v", ts.Format(0))
+	}
+
+	pixel := func(zoom, x, y, px, py int) color.RGBA {
+		buff, err := ts.GetTile(zoom, x, y)
+		if err != nil {
+			t.Fatalf("%d/%d/%d: %v", zoom, x, y, err)
+		}
+		img, err := decodeRaster(buff, FormatPNG)
+		if err != nil {
+			t.Fatal(err)
+		} else if img.Rect.Dx() != sz || img.Rect.Dy() != sz {
+			t.Fatalf("bad tile size %v", img.Rect)
+		}
+		return img.RGBAAt(px, py)
+	}
+	//the red child fills the north west quarter, its missing neighbours are transparent
+	if c := pixel(1, 0, 0, 1, 1); c != red {
+		t.Fatalf("bad red pixel %v", c)
+	} else if c = pixel(1, 0, 0, 6, 1); c.A != 0 {
+		t.Fatalf("missing child is not transparent %v", c)
+	} else if c = pixel(1, 0, 0, sz/2+1, sz/2+1); c != quadrantColors[0][0] {
+		t.Fatalf("bad quadrant pixel %v", c)
+	} else if c = pixel(1, 0, 0, sz-1, sz-1); c != quadrantColors[1][1] {
+		t.Fatalf("bad quadrant pixel %v", c)
+	} else if c = pixel(0, 0, 0, 0, 0); c != red {
+		t.Fatalf("bad zoom 0 pixel %v", c)
+	} else if c = pixel(0, 0, 0, sz-1, 0); c != white {
+		t.Fatalf("bad zoom 0 pixel %v", c)
+	}
+	//the blank quads collapse into a single blob
+	if st, err := ts.Tilemap(1).Stats(); err != nil {
+		t.Fatal(err)
+	} else if st.Tiles != 3 || st.UniqueBlobs != 2 {
+		t.Fatalf("blank quads were not deduplicated %+v", st)
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//only raster tiles can be downsampled
+	if ts, err = OpenTilesetConfig(filepath.Join(tdir, `pyramidmvt`), Config{Format: FormatMVT}); err != nil {
+		t.Fatal(err)
+	} else if err = ts.Add(1, 0, 0, testMVT); err != nil {
+		t.Fatal(err)
+	} else if err = BuildPyramid(ts, 1, PyramidConfig{}); err == nil {
+		t.Fatal("Failed to reject mvt tiles")
+	} else if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPyramidJPEG(t *testing.T) {
+	const sz = 16
+	red := color.RGBA{R: 255, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, sz, sz))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = red.R, red.A
+	}
+	buff, err := encodeRaster(img, FormatJPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := OpenTilesetConfig(filepath.Join(tdir, `pyramidjpeg`), Config{Format: FormatJPEG})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = ts.Add(1, 0, 0, buff); err != nil {
+		t.Fatal(err)
+	} else if err = ts.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	near := func(px, py int, c color.RGBA) bool {
+		buff, err := ts.GetTile(0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := decodeRaster(buff, FormatJPEG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := img.RGBAAt(px, py)
+		return near8(p.R, c.R, 24) && near8(p.G, c.G, 24) && near8(p.B, c.B, 24)
+	}
+
+	//jpeg has no transparency, missing children default to white rather than black
+	if err = BuildPyramid(ts, 1, PyramidConfig{}); err != nil {
+		t.Fatal(err)
+	} else if !near(sz/4, sz/4, red) {
+		t.Fatal("bad red quadrant")
+	} else if !near(sz*3/4, sz*3/4, jpegBackground) {
+		t.Fatal("missing child is not white")
+	}
+	blue := color.RGBA{B: 255, A: 255}
+	if err = BuildPyramid(ts, 1, PyramidConfig{Background: blue}); err != nil {
+		t.Fatal(err)
+	} else if !near(sz/4, sz/4, red) {
+		t.Fatal("bad red quadrant")
+	} else if !near(sz*3/4, sz*3/4, blue) {
+		t.Fatal("missing child is not the background")
+	} else if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+package tilemap
+
+import (
+	"encoding/binary"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	seqSpins = 1 << 16 //attempts before a reader stops waiting on an odd sequence
+)
+
+// Refresh picks up tiles appended by another process and swaps in a file replaced by Compact.
+// It is a no-op for writers and tilemaps not backed by their own file.
+func (w *Tilemap) Refresh() (err error) {
+	w.Lock()
+	defer w.Unlock()
+	fs, ok := w.st.(*fileStorage)
+	if !w.ro || !ok || fs.shared {
+		return
+	}
+	var fi, pfi os.FileInfo
+	if pfi, err = os.Stat(w.pth); err != nil {
+		return
+	} else if fi, err = fs.stat(); err != nil {
+		return
+	}
+	if !os.SameFile(fi, pfi) {
+		var nw *Tilemap
+		if nw, err = NewTilemapConfig(w.pth, Config{Zoom: w.zoom, ReadOnly: true, Hash: w.hash}); err != nil {
+			return
+		}
+		err = w.swap(nw)
+		return
+	}
+	if fi.Size() > w.foff {
+		w.foff = fi.Size()
+		if w.mapped && w.foff > int64(len(w.dmm)) {
+			err = w.mapData()
+		}
+	}
+	//the header lives in the shared mapping, pick up any metadata changes
+	if !w.hdr.Legacy() {
+		var hdr Header
+		if hdr.Decode(w.mm) != nil {
+			return
+		}
+		if hdr.Index == IndexSparse && (hdr.idxOffset != w.hdr.idxOffset || hdr.idxSlots != w.hdr.idxSlots) {
+			//the writer grew the sparse index into a new table
+			if hdr.idxOffset+hdr.indexSize() > w.foff {
+				return //not fully visible yet, try again next time
+			} else if err = w.mapIndex(hdr); err != nil {
+				return
+			}
+		}
+		w.hdr = hdr
+	}
+	return
+}
+
+// swap replaces the backing storage with the storage of another tilemap, nw is consumed
+func (w *Tilemap) swap(nw *Tilemap) (err error) {
+	w.unmap()
+	w.st.close()
+	w.st = nw.st
+	w.hreg = nw.hreg
+	w.mm = nw.mm
+	w.ireg = nw.ireg
+	w.used = nw.used
+	w.idx = nw.idx
+	w.hdr = nw.hdr
+	w.dataStart = nw.dataStart
+	w.foff = nw.foff
+	if w.mapped {
+		//outstanding views keep pointing at the old file until Close
+		w.oldMaps = append(w.oldMaps, w.dreg)
+		w.dreg = nil
+		err = w.mapData()
+	}
+	return
+}
+
+func (w *Tilemap) startRefresh(interval time.Duration) {
+	w.refreshStop = make(chan es)
+	w.refreshWg.Add(1)
+	go func() {
+		defer w.refreshWg.Done()
+		tckr := time.NewTicker(interval)
+		defer tckr.Stop()
+		for {
+			select {
+			case <-tckr.C:
+				w.Refresh() //errors are transient, try again next tick
+			case <-w.refreshStop:
+				return
+			}
+		}
+	}()
+}
+
+func (w *Tilemap) stopRefresh() {
+	if w.refreshStop != nil {
+		close(w.refreshStop)
+		w.refreshWg.Wait()
+		w.refreshStop = nil
+	}
+}
+
+// idxSeq returns the index sequence in the shared header.  Legacy files have no header to hold
+// it and memory tilemaps handed an unaligned slice are never shared, both return nil.
+func (w *Tilemap) idxSeq() *uint32 {
+	if w.hdr.Legacy() || len(w.mm) < hdrSeqOffset+4 {
+		return nil
+	}
+	p := unsafe.Pointer(&w.mm[hdrSeqOffset])
+	if uintptr(p)%4 != 0 {
+		return nil
+	}
+	return (*uint32)(p)
+}
+
+// writeIndex runs fn, which changes the index, with the index sequence odd
+func (w *Tilemap) writeIndex(fn func()) {
+	seq := w.idxSeq()
+	if seq != nil {
+		atomic.AddUint32(seq, 1)
+	}
+	fn()
+	if seq != nil {
+		atomic.AddUint32(seq, 1)
+	}
+}
+
+// readIndex runs fn, which reads the index, until it runs without a writer in another process
+// changing the index underneath it.  Writers hold the only copy of the index they change.
+func (w *Tilemap) readIndex(fn func()) {
+	seq := w.idxSeq()
+	if !w.ro || seq == nil {
+		fn()
+		return
+	}
+	for i := 0; ; i++ {
+		s := atomic.LoadUint32(seq)
+		if s&1 == 0 || i >= seqSpins {
+			//a writer that died mid change leaves the sequence odd, don't wait on it forever
+			fn()
+			if atomic.LoadUint32(seq) == s {
+				return
+			}
+		}
+		runtime.Gosched()
+	}
+}
+
+// resetIndexSeq makes the index sequence even again after a writer died part way through a change
+func (w *Tilemap) resetIndexSeq() {
+	if seq := w.idxSeq(); seq != nil && atomic.LoadUint32(seq)&1 != 0 {
+		atomic.AddUint32(seq, 1)
+	}
+}
+
+// loadShared copies len(dst) bytes at off in b into dst, loading the aligned words of b atomically
+func loadShared(dst, b []byte, off int) {
+	lead, end := sharedWords(b)
+	for i := 0; i < len(dst); {
+		p := off + i
+		if p < lead || p >= end {
+			dst[i] = b[p]
+			i++
+			continue
+		}
+		ws := p - (p-lead)%4
+		var wb [4]byte
+		binary.NativeEndian.PutUint32(wb[:], atomic.LoadUint32((*uint32)(unsafe.Pointer(&b[ws]))))
+		i += copy(dst[i:], wb[p-ws:])
+	}
+}
+
+// storeShared copies src into b at off, storing the aligned words of b atomically
+func storeShared(b []byte, off int, src []byte) {
+	lead, end := sharedWords(b)
+	for i := 0; i < len(src); {
+		p := off + i
+		if p < lead || p >= end {
+			b[p] = src[i]
+			i++
+			continue
+		}
+		ws := p - (p-lead)%4
+		wp := (*uint32)(unsafe.Pointer(&b[ws]))
+		var wb [4]byte
+		binary.NativeEndian.PutUint32(wb[:], atomic.LoadUint32(wp))
+		i += copy(wb[p-ws:], src[i:])
+		atomic.StoreUint32(wp, binary.NativeEndian.Uint32(wb[:]))
+	}
+}
+
+// sharedWords returns the span of b covered by whole aligned 32 bit words
+func sharedWords(b []byte) (lead, end int) {
+	if len(b) == 0 {
+		return
+	}
+	lead = min(int(-uintptr(unsafe.Pointer(&b[0]))&3), len(b))
+	end = lead + (len(b)-lead)&^3
+	return
+}
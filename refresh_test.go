@@ -0,0 +1,143 @@
+package tilemap
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTilemapRefresh(t *testing.T) {
+	zl := 2
+	pth := filepath.Join(tdir, `refresh`)
+	wtr, err := NewTilemap(pth, zl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Add(0, 0, []byte(`before`)); err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewTilemapConfig(pth, Config{Zoom: zl, ReadOnly: true, MapData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//tiles written after the reader opened are not visible until a refresh
+	if err = wtr.Add(1, 1, []byte(`after`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.GetTile(1, 1); err != ErrTileNotFound {
+		t.Fatalf("unrefreshed reader did not report ErrTileNotFound: %v", err)
+	} else if err = rdr.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if buff, err := rdr.GetTile(1, 1); err != nil {
+		t.Fatal(err)
+	} else if string(buff) != `after` {
+		t.Fatalf("bad tile %q", buff)
+	}
+
+	//a compacted file is swapped in
+	if err = wtr.Add(1, 1, []byte(`replaced`)); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Compact(); err != nil {
+		t.Fatal(err)
+	} else if err = rdr.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := rdr.GetTileView(1, 1); err != nil {
+		t.Fatal(err)
+	} else if string(view) != `replaced` {
+		t.Fatalf("bad tile %q", view)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//automatic refresh
+	if rdr, err = NewTilemapConfig(pth, Config{Zoom: zl, ReadOnly: true, RefreshInterval: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Add(0, 1, []byte(`polled`)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !rdr.Has(0, 1) {
+		if time.Now().After(deadline) {
+			t.Fatal("automatic refresh never picked up the new tile")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if buff, err := rdr.GetTile(0, 1); err != nil {
+		t.Fatal(err)
+	} else if string(buff) != `polled` {
+		t.Fatalf("bad tile %q", buff)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTilemapIndexSequence(t *testing.T) {
+	zl := 2
+	pth := filepath.Join(tdir, `idxseq`)
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Index: IndexSparse})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []string{`first`, `second`}
+	for i, c := range contents {
+		if err = wtr.Add(i, 0, []byte(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = wtr.Add(1, 1, []byte(contents[0])); err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewTilemapConfig(pth, Config{Zoom: zl, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//replacing a tile with an existing blob only rewrites its datapointer, the reader must
+	//always see one whole datapointer or the other
+	done := make(chan error, 1)
+	go func() {
+		var lerr error
+		for i := 0; i < 2000 && lerr == nil; i++ {
+			lerr = wtr.Add(1, 1, []byte(contents[i%2]))
+		}
+		done <- lerr
+	}()
+	for running := true; running; {
+		select {
+		case err = <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+		}
+		if buff, err := rdr.GetTile(1, 1); err != nil {
+			t.Fatal(err)
+		} else if string(buff) != contents[0] && string(buff) != contents[1] {
+			t.Fatalf("reader saw a torn tile %q", buff)
+		}
+	}
+
+	//the sequence is even once the writer is done and header updates leave it alone
+	seq := *wtr.idxSeq()
+	if seq == 0 || seq&1 != 0 {
+		t.Fatalf("bad index sequence %d", seq)
+	} else if err = wtr.SetMetadata(Metadata{Name: `seq`}); err != nil {
+		t.Fatal(err)
+	} else if *wtr.idxSeq() != seq {
+		t.Fatalf("header update changed the index sequence %d != %d", *wtr.idxSeq(), seq)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package tilemap
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidRegion = errors.New("invalid tilemap region")
+	ErrOutsideRegion = errors.New("tile is outside the tilemap region")
+)
+
+// Region restricts a tilemap to a window of tiles starting at X, Y, the zero value covers the entire map
+type Region struct {
+	X, Y          int //origin, the north west tile of the window
+	Width, Height int //extent in tiles
+}
+
+// Set returns true if the region restricts the map
+func (r Region) Set() bool {
+	return r.Width != 0 || r.Height != 0
+}
+
+// Bounds returns the inclusive tile bounds covered by the region at zoom
+func (r Region) Bounds(zoom int) TileBounds {
+	if !r.Set() {
+		dim := 1 << uint(zoom)
+		return TileBounds{MaxX: dim - 1, MaxY: dim - 1}
+	}
+	return TileBounds{MinX: r.X, MinY: r.Y, MaxX: r.X + r.Width - 1, MaxY: r.Y + r.Height - 1}
+}
+
+// Contains returns true if x, y is inside the region
+func (r Region) Contains(x, y int) bool {
+	if !r.Set() {
+		return true
+	}
+	return x >= r.X && x < r.X+r.Width && y >= r.Y && y < r.Y+r.Height
+}
+
+// union returns the smallest region covering both r and o, a region covering the entire map absorbs everything
+func (r Region) union(o Region) Region {
+	if !r.Set() || !o.Set() {
+		return Region{}
+	}
+	x, y := min(r.X, o.X), min(r.Y, o.Y)
+	return Region{
+		X:      x,
+		Y:      y,
+		Width:  max(r.X+r.Width, o.X+o.Width) - x,
+		Height: max(r.Y+r.Height, o.Y+o.Height) - y,
+	}
+}
+
+// valid checks that the region lies within the map at zoom
+func (r Region) valid(zoom int) bool {
+	if !r.Set() {
+		return true
+	}
+	dim := int64(1) << uint(zoom)
+	return r.X >= 0 && r.Y >= 0 && r.Width > 0 && r.Height > 0 &&
+		int64(r.X)+int64(r.Width) <= dim && int64(r.Y)+int64(r.Height) <= dim
+}
+
+// curveZoom returns the zoom of the smallest square map that holds the region,
+// curves other than row major index the region as if it were that map
+func (r Region) curveZoom() (z int) {
+	for edge := max(r.Width, r.Height); (1 << uint(z)) < edge; z++ {
+	}
+	return
+}
+
+// tileCount returns the number of tile ids in the index of a map at zoom using curve c
+func (r Region) tileCount(c Curve, zoom int) int64 {
+	if !r.Set() {
+		return tileCount(zoom)
+	} else if c == CurveRowMajor {
+		return int64(r.Width) * int64(r.Height)
+	}
+	return tileCount(r.curveZoom())
+}
+
+// tileid returns the id of x, y within the region, ids are relative to the origin
+func (r Region) tileid(c Curve, zoom, x, y int) uint64 {
+	if !r.Set() {
+		return c.TileID(zoom, x, y)
+	}
+	x -= r.X
+	y -= r.Y
+	if c == CurveRowMajor {
+		return uint64(x)*uint64(r.Height) + uint64(y)
+	}
+	return c.TileID(r.curveZoom(), x, y)
+}
+
+// tilexy is the inverse of tileid
+func (r Region) tilexy(c Curve, zoom int, tid uint64) (x, y int) {
+	if !r.Set() {
+		return c.TileXY(zoom, tid)
+	}
+	if c == CurveRowMajor {
+		x, y = int(tid/uint64(r.Height)), int(tid%uint64(r.Height))
+	} else {
+		x, y = c.TileXY(r.curveZoom(), tid)
+	}
+	return x + r.X, y + r.Y
+}
@@ -0,0 +1,167 @@
+package tilemap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegion(t *testing.T) {
+	for _, c := range []Curve{CurveRowMajor, CurveMorton, CurveHilbert} {
+		t.Run(c.String(), func(t *testing.T) {
+			testRegion(t, c)
+		})
+	}
+}
+
+func testRegion(t *testing.T, c Curve) {
+	zl := 16
+	rg := Region{X: 35000, Y: 21000, Width: 40, Height: 25}
+	pth := filepath.Join(tdir, `region`+c.String())
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Region: rg, Curve: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a small window at zoom 16 gets a dense index sized to the window
+	hdr := wtr.Header()
+	if hdr.Index != IndexDense || hdr.Region != rg || hdr.Version != headerVersion {
+		t.Fatalf("bad regional header %+v", hdr)
+	} else if fi, err := os.Stat(pth); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() > headerSize+64*64*dpsize {
+		t.Fatalf("regional index is %d bytes", fi.Size())
+	}
+
+	expected := make(map[TileCoord]string)
+	for x := rg.X; x < rg.X+rg.Width; x += 3 {
+		for y := rg.Y; y < rg.Y+rg.Height; y += 2 {
+			buff := fmt.Sprintf("%d/%d", x, y)
+			if err = wtr.Add(x, y, []byte(buff)); err != nil {
+				t.Fatal(err)
+			}
+			expected[TileCoord{Zoom: zl, X: x, Y: y}] = buff
+		}
+	}
+	for _, xy := range [][2]int{{rg.X - 1, rg.Y}, {rg.X, rg.Y + rg.Height}, {0, 0}} {
+		if err = wtr.Add(xy[0], xy[1], []byte(`outside`)); err == nil {
+			t.Fatalf("Failed to refuse tile outside the region %v", xy)
+		} else if _, err = wtr.GetTile(xy[0], xy[1]); err != ErrTileNotFound {
+			t.Fatalf("tile outside the region %v: %v", xy, err)
+		}
+	}
+	if _, err = wtr.GetTile(1<<uint(zl), 0); err != ErrInvalidTileID {
+		t.Fatalf("Failed to catch tile outside the map: %v", err)
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if rdr.Header().Region != rg {
+		t.Fatalf("region lost on reopen %+v", rdr.Header())
+	}
+	checkRegion(t, rdr, rg, expected)
+	n := 0
+	for ti := range rdr.Tiles(WalkOptions{}) {
+		if _, ok := expected[TileCoord{Zoom: zl, X: ti.X, Y: ti.Y}]; !ok {
+			t.Fatalf("walk returned unexpected tile %d %d", ti.X, ti.Y)
+		}
+		n++
+	}
+	if n != len(expected) {
+		t.Fatalf("walk returned %d tiles, expected %d", n, len(expected))
+	}
+
+	//rewriting into a smaller region crops the map
+	crop := Region{X: rg.X + 10, Y: rg.Y + 10, Width: 10, Height: 10}
+	out := filepath.Join(tdir, `regioncrop`+c.String())
+	if err = Rewrite(rdr, out, Config{Curve: c, Region: crop}); err != nil {
+		t.Fatal(err)
+	} else if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for tc := range expected {
+		if !crop.Contains(tc.X, tc.Y) {
+			delete(expected, tc)
+		}
+	}
+	if rdr, err = OpenTilemap(out, false); err != nil {
+		t.Fatal(err)
+	} else if err = rdr.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkRegion(t, rdr, crop, expected)
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRegion(t *testing.T, tm *Tilemap, rg Region, expected map[TileCoord]string) {
+	for x := rg.X - 1; x <= rg.X+rg.Width; x++ {
+		for y := rg.Y - 1; y <= rg.Y+rg.Height; y++ {
+			want, ok := expected[TileCoord{Zoom: tm.Zoom(), X: x, Y: y}]
+			buff, err := tm.GetTile(x, y)
+			if !ok {
+				if err != ErrTileNotFound {
+					t.Fatalf("tile %d %d should be missing: %v", x, y, err)
+				}
+			} else if err != nil {
+				t.Fatalf("tile %d %d: %v", x, y, err)
+			} else if string(buff) != want {
+				t.Fatalf("bad tile %d %d: %q != %q", x, y, buff, want)
+			}
+		}
+	}
+}
+
+func TestRegionHeader(t *testing.T) {
+	//plain files keep writing version 1 headers
+	wtr, err := NewTilemapConfig(filepath.Join(tdir, `regionplain`), Config{Zoom: 3})
+	if err != nil {
+		t.Fatal(err)
+	} else if hdr := wtr.Header(); hdr.Version != headerVersionV1 || hdr.Region.Set() {
+		t.Fatalf("bad plain header %+v", hdr)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//windows at zoom 20 are dense until they cover more tiles than zoom 16
+	rg := Region{X: 1 << 19, Y: 1 << 19, Width: 1 << 10, Height: 1 << 9}
+	if wtr, err = NewTilemapConfig(filepath.Join(tdir, `regionsparse`), Config{Zoom: 20, Region: rg}); err != nil {
+		t.Fatal(err)
+	} else if hdr := wtr.Header(); hdr.Index != IndexDense {
+		t.Fatalf("window should be dense %+v", hdr)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rg.Width, rg.Height = 1<<19, 1<<19
+	if wtr, err = NewTilemapConfig(filepath.Join(tdir, `regionsparse2`), Config{Zoom: 20, Region: rg}); err != nil {
+		t.Fatal(err)
+	} else if hdr := wtr.Header(); hdr.Index != IndexSparse {
+		t.Fatalf("window should be sparse %+v", hdr)
+	} else if err = wtr.Add(rg.X+rg.Width-1, rg.Y, []byte(`edge`)); err != nil {
+		t.Fatal(err)
+	} else if buff, err := wtr.GetTile(rg.X+rg.Width-1, rg.Y); err != nil || string(buff) != `edge` {
+		t.Fatalf("bad sparse regional tile %q %v", buff, err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []Region{
+		{X: 6, Y: 0, Width: 4, Height: 1},
+		{X: 0, Y: 0, Width: 1, Height: 0},
+		{X: -1, Y: 0, Width: 2, Height: 2},
+	} {
+		if _, err = NewTilemapConfig(filepath.Join(tdir, `regionbad`), Config{Zoom: 3, Region: bad}); err != ErrInvalidRegion {
+			t.Fatalf("Failed to catch bad region %+v: %v", bad, err)
+		}
+		os.Remove(filepath.Join(tdir, `regionbad`))
+	}
+	h := Header{Version: headerVersionV1, Zoom: 3, MimeType: DefaultMimeType, Region: Region{Width: 1, Height: 1}}
+	if err = h.Encode(make([]byte, headerSize)); err != ErrUnsupportedVersion {
+		t.Fatalf("Failed to refuse a region in a version 1 header: %v", err)
+	}
+}
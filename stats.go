@@ -0,0 +1,168 @@
+package tilemap
+
+import (
+	"errors"
+	"sort"
+)
+
+const (
+	// DefaultStatsTop is the number of most shared blobs reported by Stats
+	DefaultStatsTop = 10
+)
+
+var (
+	errStatsDone = errors.New("stats done")
+)
+
+// Stats describes the contents and space usage of a tilemap
+type Stats struct {
+	Tiles       int64   //populated tiles
+	UniqueBlobs int64   //distinct blobs referenced by tiles
+	BlobBytes   int64   //bytes held by unique blobs, excluding checksums
+	AvgBlobSize int64   //BlobBytes / UniqueBlobs
+	DedupRatio  float64 //Tiles / UniqueBlobs
+	IndexBytes  int64   //size of the tile index
+	DeadBytes   int64   //bytes orphaned by overwrites, deletes, and outgrown sparse index tables
+	FileBytes   int64   //total size of the tilemap
+	TopShared   []SharedBlob
+}
+
+// SharedBlob describes a blob referenced by more than one tile
+type SharedBlob struct {
+	Refs int64 //number of tiles referencing the blob
+	Size int64
+	X, Y int //one of the tiles referencing the blob
+}
+
+type blobStat struct {
+	refs int64
+	size int64
+	tid  uint64
+	set  bool //tid is valid
+}
+
+// Stats returns tile, blob, and space usage statistics along with the DefaultStatsTop most shared blobs
+func (w *Tilemap) Stats() (Stats, error) {
+	return w.StatsTop(DefaultStatsTop)
+}
+
+// StatsTop returns statistics with the n most shared blobs.  Writers that have built their
+// deduplication state answer from it, otherwise the index is scanned.
+func (w *Tilemap) StatsTop(n int) (st Stats, err error) {
+	w.RLock()
+	defer w.RUnlock()
+	var blobs map[int64]*blobStat
+	var live int64
+	if w.refs != nil {
+		blobs, live, err = w.statsFromRefs(n)
+	} else {
+		blobs, live, err = w.statsFromIndex()
+	}
+	if err != nil {
+		return
+	}
+	for _, b := range blobs {
+		st.Tiles += b.refs
+	}
+	st.UniqueBlobs = int64(len(blobs))
+	st.BlobBytes = live - st.UniqueBlobs*w.trailer()
+	if st.UniqueBlobs > 0 {
+		st.AvgBlobSize = st.BlobBytes / st.UniqueBlobs
+		st.DedupRatio = float64(st.Tiles) / float64(st.UniqueBlobs)
+	}
+	st.IndexBytes = w.hdr.indexSize()
+	st.DeadBytes = w.deadBytes(live)
+	st.FileBytes = w.foff
+	st.TopShared = w.topShared(blobs, n)
+	return
+}
+
+// statsFromIndex scans the index and totals every referenced blob
+func (w *Tilemap) statsFromIndex() (blobs map[int64]*blobStat, live int64, err error) {
+	blobs = make(map[int64]*blobStat)
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		if !w.validDataPointer(dp) {
+			return nil
+		}
+		b, ok := blobs[dp.offset]
+		if !ok {
+			b = &blobStat{size: dp.size, tid: tid, set: true}
+			blobs[dp.offset] = b
+			live += w.extent(dp)
+		}
+		b.refs++
+		return nil
+	})
+	return
+}
+
+// statsFromRefs uses the reference counts kept by writers, only the n most shared blobs
+// need their size and a tile, which a partial index scan fills in
+func (w *Tilemap) statsFromRefs(n int) (blobs map[int64]*blobStat, live int64, err error) {
+	blobs = make(map[int64]*blobStat, len(w.refs))
+	for off, cnt := range w.refs {
+		blobs[off] = &blobStat{refs: int64(cnt)}
+	}
+	live = w.live
+	top := w.topOffsets(blobs, n)
+	if len(top) == 0 {
+		return
+	}
+	need := len(top)
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		if b, ok := top[dp.offset]; ok && !b.set {
+			b.size = dp.size
+			b.tid = tid
+			b.set = true
+			if need--; need == 0 {
+				return errStatsDone
+			}
+		}
+		return nil
+	})
+	if err == errStatsDone {
+		err = nil
+	}
+	return
+}
+
+// topOffsets picks the n blobs with the most references, only blobs that are shared are considered
+func (w *Tilemap) topOffsets(blobs map[int64]*blobStat, n int) (top map[int64]*blobStat) {
+	offs := make([]int64, 0, len(blobs))
+	for off, b := range blobs {
+		if b.refs > 1 {
+			offs = append(offs, off)
+		}
+	}
+	sort.Slice(offs, func(i, j int) bool {
+		if bi, bj := blobs[offs[i]], blobs[offs[j]]; bi.refs != bj.refs {
+			return bi.refs > bj.refs
+		}
+		return offs[i] < offs[j]
+	})
+	if len(offs) > n {
+		offs = offs[:max(n, 0)]
+	}
+	top = make(map[int64]*blobStat, len(offs))
+	for _, off := range offs {
+		top[off] = blobs[off]
+	}
+	return
+}
+
+func (w *Tilemap) topShared(blobs map[int64]*blobStat, n int) (r []SharedBlob) {
+	for _, b := range w.topOffsets(blobs, n) {
+		if !b.set {
+			continue
+		}
+		x, y := w.tilexy(b.tid)
+		r = append(r, SharedBlob{Refs: b.refs, Size: b.size, X: x, Y: y})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Refs != r[j].Refs {
+			return r[i].Refs > r[j].Refs
+		}
+		return r[i].Size > r[j].Size
+	})
+	return
+}
@@ -0,0 +1,86 @@
+package tilemap
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTilemapStats(t *testing.T) {
+	zl := 3
+	pth := filepath.Join(tdir, `stats`)
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocean := []byte(`ocean ocean ocean`)
+	land := []byte(`land`)
+	//row 0 is ocean, row 1 is land, a few unique tiles in row 2
+	for y := 0; y < 8; y++ {
+		if err = wtr.Add(0, y, ocean); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for y := 0; y < 3; y++ {
+		if err = wtr.Add(1, y, land); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unique := [][]byte{[]byte(`a`), []byte(`bb`), []byte(`ccc`)}
+	for y, b := range unique {
+		if err = wtr.Add(2, y, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//overwrite one of the unique tiles to orphan its blob
+	if err = wtr.Add(2, 2, ocean); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := wtr.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobBytes := int64(len(ocean) + len(land) + 1 + 2)
+	if st.Tiles != 14 || st.UniqueBlobs != 4 || st.BlobBytes != blobBytes || st.AvgBlobSize != blobBytes/4 {
+		t.Fatalf("bad stats %+v", st)
+	} else if st.DedupRatio != 14.0/4.0 {
+		t.Fatalf("bad dedup ratio %f", st.DedupRatio)
+	} else if st.DeadBytes != 3+checksumSize {
+		t.Fatalf("bad dead bytes %d", st.DeadBytes)
+	} else if st.IndexBytes != tileCount(zl)*dpsize || st.FileBytes != wtr.size() {
+		t.Fatalf("bad sizes %+v", st)
+	}
+	if len(st.TopShared) != 2 {
+		t.Fatalf("bad top shared %+v", st.TopShared)
+	} else if top := st.TopShared[0]; top.Refs != 9 || top.Size != int64(len(ocean)) {
+		t.Fatalf("bad top shared blob %+v", top)
+	} else if buff, err := wtr.GetTile(top.X, top.Y); err != nil || string(buff) != string(ocean) {
+		t.Fatalf("top shared blob points at the wrong tile %+v %v", top, err)
+	} else if st.TopShared[1].Refs != 3 {
+		t.Fatalf("bad second shared blob %+v", st.TopShared[1])
+	}
+	if st, err := wtr.StatsTop(1); err != nil {
+		t.Fatal(err)
+	} else if len(st.TopShared) != 1 {
+		t.Fatalf("bad top shared %+v", st.TopShared)
+	}
+
+	//a reader has no dedup state and has to scan, the answer must match
+	rdr, err := OpenTilemap(pth, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rst, err := rdr.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(st, rst) {
+		t.Fatalf("reader stats do not match writer stats\n%+v\n%+v", rst, st)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
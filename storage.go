@@ -0,0 +1,488 @@
+package tilemap
+
+import (
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/tysontate/gommap"
+)
+
+const (
+	wtrMapFlags  = gommap.PROT_WRITE | gommap.PROT_READ
+	wtrOpenFlags = os.O_RDWR | os.O_CREATE
+	rdrMapFlags  = gommap.PROT_READ
+	rdrOpenFlags = os.O_RDONLY
+)
+
+// storage holds the bytes of a tilemap, offsets are relative to the start of the tilemap
+type storage interface {
+	io.ReaderAt
+	io.WriterAt
+	size() (int64, error)
+	grow(sz int64) error //ensure the storage holds at least sz bytes
+	mapRegion(off, sz int64, writable bool) (region, error)
+	mapData(sz int64) (region, error) //read only region covering at least the first sz bytes
+	sync() error                      //flush anything written with WriteAt
+	close() error
+}
+
+// region is a piece of storage that is accessed directly, writes to the slice are writes to the storage
+type region interface {
+	bytes() []byte
+	sync(n int64) error //flush the first n bytes, or all of them if n <= 0
+	unmap() error
+}
+
+// NewMemoryTilemap creates a tilemap held in memory, an empty buf creates a new tilemap described by c.
+// Otherwise buf must hold a tilemap such as one saved with WriteTo, the tilemap takes ownership of it.
+func NewMemoryTilemap(buf []byte, c Config) (w *Tilemap, err error) {
+	if c.Zoom < AnyZoom || c.Zoom > MaxZoom {
+		err = ErrInvalidDimension
+		return
+	}
+	st := &memStorage{data: buf, ro: c.ReadOnly}
+	if w, err = newTilemap(st, c); err != nil {
+		st.close()
+	}
+	return
+}
+
+// OpenReaderTilemap opens a read only tilemap of size bytes that is served from rdr
+func OpenReaderTilemap(rdr io.ReaderAt, size int64, c Config) (w *Tilemap, err error) {
+	if c.Zoom < AnyZoom || c.Zoom > MaxZoom {
+		err = ErrInvalidDimension
+		return
+	}
+	c.ReadOnly = true
+	w, err = newTilemap(&readerStorage{rdr: rdr, sz: size}, c)
+	return
+}
+
+// OpenTilemapFS opens a read only tilemap out of a file system such as an embed.FS.
+// Files that do not support random access are read into memory.
+func OpenTilemapFS(fsys fs.FS, name string, c Config) (w *Tilemap, err error) {
+	var st storage
+	if c.Zoom < AnyZoom || c.Zoom > MaxZoom {
+		err = ErrInvalidDimension
+		return
+	} else if st, err = openFSStorage(fsys, name); err != nil {
+		return
+	}
+	c.ReadOnly = true
+	if w, err = newTilemap(st, c); err != nil {
+		st.close()
+	}
+	return
+}
+
+// WriteTo writes the entire tilemap to wtr, the copy is marked clean
+func (w *Tilemap) WriteTo(wtr io.Writer) (n int64, err error) {
+	w.Lock()
+	defer w.Unlock()
+	if !w.ro {
+		if err = w.flush(); err != nil {
+			return
+		}
+	}
+	var start int64
+	if hdrSize := w.hdr.size(); hdrSize > 0 {
+		hdr := w.hdr
+		hdr.Dirty = false
+		buff := make([]byte, hdrSize)
+		if err = hdr.Encode(buff); err != nil {
+			return
+		}
+		var wn int
+		wn, err = wtr.Write(buff)
+		if n = int64(wn); err != nil {
+			return
+		}
+		start = hdrSize
+	}
+	var cn int64
+	cn, err = io.Copy(wtr, io.NewSectionReader(w.st, start, w.foff-start))
+	n += cn
+	return
+}
+
+// openFSStorage opens a file out of fsys as read only storage
+func openFSStorage(fsys fs.FS, name string) (st storage, err error) {
+	var f fs.File
+	var fi fs.FileInfo
+	if f, err = fsys.Open(name); err != nil {
+		return
+	} else if fi, err = f.Stat(); err != nil {
+		f.Close()
+		return
+	}
+	if rdr, ok := f.(io.ReaderAt); ok {
+		st = &readerStorage{rdr: rdr, sz: fi.Size(), closer: f}
+		return
+	}
+	var buf []byte
+	if buf, err = io.ReadAll(f); err != nil {
+		f.Close()
+		return
+	} else if err = f.Close(); err != nil {
+		return
+	}
+	st = &memStorage{data: buf, ro: true}
+	return
+}
+
+// fileStorage memory maps the header and index of a file, it may be a section of a container
+type fileStorage struct {
+	fio    *os.File
+	base   int64 //offset of the tilemap within the file
+	limit  int64 //size of a tilemap embedded in a container
+	shared bool  //file is owned by a container
+	ro     bool
+}
+
+func openFileStorage(pth string, ro bool) (s *fileStorage, err error) {
+	oflags := wtrOpenFlags
+	if ro {
+		oflags = rdrOpenFlags
+	}
+	var fio *os.File
+	if fio, err = os.OpenFile(pth, oflags, 0640); err != nil {
+		return
+	}
+	//best effort, may not be supported
+	setAttr(fio, NO_COW)
+	s = &fileStorage{fio: fio, ro: ro}
+	return
+}
+
+// sectionStorage serves a read only tilemap stored at base within a container file
+func sectionStorage(fio *os.File, base, size int64) *fileStorage {
+	return &fileStorage{fio: fio, base: base, limit: size, shared: true, ro: true}
+}
+
+func (s *fileStorage) ReadAt(b []byte, off int64) (int, error) {
+	return s.fio.ReadAt(b, s.base+off)
+}
+
+func (s *fileStorage) WriteAt(b []byte, off int64) (int, error) {
+	if s.ro {
+		return 0, ErrReadOnly
+	}
+	return s.fio.WriteAt(b, s.base+off)
+}
+
+func (s *fileStorage) size() (sz int64, err error) {
+	if s.shared {
+		sz = s.limit
+		return
+	}
+	var fi os.FileInfo
+	if fi, err = s.fio.Stat(); err == nil {
+		sz = fi.Size()
+	}
+	return
+}
+
+func (s *fileStorage) stat() (os.FileInfo, error) {
+	return s.fio.Stat()
+}
+
+func (s *fileStorage) grow(sz int64) (err error) {
+	var cur int64
+	if cur, err = s.size(); err != nil || cur >= sz {
+		return
+	} else if s.ro {
+		err = ErrReadOnly
+		return
+	}
+	err = safeFallocate(s.fio, cur, sz)
+	return
+}
+
+func (s *fileStorage) mapRegion(off, sz int64, writable bool) (r region, err error) {
+	flags := rdrMapFlags
+	if writable {
+		if s.ro {
+			err = ErrReadOnly
+			return
+		}
+		flags = wtrMapFlags
+	}
+	var mr mmapRegion
+	if mr.mm, mr.b, err = mapRegion(s.fio, s.base+off, sz, flags); err == nil {
+		r = mr
+	}
+	return
+}
+
+// mapData maps with headroom so appends rarely remap, container sections never grow
+func (s *fileStorage) mapData(sz int64) (region, error) {
+	if !s.shared {
+		sz = (sz/dataMapGrowth + 1) * dataMapGrowth
+	}
+	return s.mapRegion(0, sz, false)
+}
+
+func (s *fileStorage) sync() error {
+	return s.fio.Sync()
+}
+
+// close releases the file, containers close their own file
+func (s *fileStorage) close() error {
+	if s.shared {
+		return nil
+	}
+	return s.fio.Close()
+}
+
+// mapRegion maps sz bytes of the file at off, the mapping starts on the page boundary before off
+func mapRegion(fio *os.File, off, sz int64, flags gommap.ProtFlags) (mm gommap.MMap, b []byte, err error) {
+	pg := int64(os.Getpagesize())
+	start := off - off%pg
+	if mm, err = gommap.MapRegion(fio.Fd(), start, sz+off-start, flags, gommap.MAP_SHARED); err != nil {
+		return
+	}
+	b = mm[off-start:]
+	return
+}
+
+type mmapRegion struct {
+	mm gommap.MMap
+	b  []byte
+}
+
+func (r mmapRegion) bytes() []byte {
+	return r.b
+}
+
+func (r mmapRegion) sync(n int64) error {
+	if n <= 0 || n >= int64(len(r.b)) {
+		return r.mm.Sync(gommap.MS_SYNC)
+	}
+	lead := int64(len(r.mm) - len(r.b))
+	return gommap.MMap(r.mm[:lead+n]).Sync(gommap.MS_SYNC)
+}
+
+func (r mmapRegion) unmap() error {
+	return r.mm.UnsafeUnmap()
+}
+
+// memStorage keeps a tilemap in a byte slice, writable regions get their own buffers so the slice can grow
+type memStorage struct {
+	data    []byte
+	ro      bool
+	regions []*memRegion
+}
+
+func (s *memStorage) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		err = ErrInvalidBufferSize
+		return
+	} else if off >= int64(len(s.data)) {
+		err = io.EOF
+		return
+	}
+	n = copy(b, s.data[off:])
+	for _, r := range s.regions {
+		overlay(b[:n], off, r.b, r.off)
+	}
+	if n < len(b) {
+		err = io.EOF
+	}
+	return
+}
+
+func (s *memStorage) WriteAt(b []byte, off int64) (n int, err error) {
+	if s.ro {
+		err = ErrReadOnly
+		return
+	} else if off < 0 {
+		err = ErrInvalidBufferSize
+		return
+	}
+	s.extend(off + int64(len(b)))
+	n = copy(s.data[off:], b)
+	for _, r := range s.regions {
+		overlay(r.b, r.off, b, off)
+	}
+	return
+}
+
+// overlay copies the part of src at soff that overlaps dst at doff
+func overlay(dst []byte, doff int64, src []byte, soff int64) {
+	start := max(doff, soff)
+	end := min(doff+int64(len(dst)), soff+int64(len(src)))
+	if start < end {
+		copy(dst[start-doff:end-doff], src[start-soff:end-soff])
+	}
+}
+
+// extend grows the slice to sz bytes, doubling the capacity when it has to reallocate
+func (s *memStorage) extend(sz int64) {
+	if sz <= int64(len(s.data)) {
+		return
+	} else if sz > int64(cap(s.data)) {
+		nd := make([]byte, sz, max(sz, 2*int64(cap(s.data))))
+		copy(nd, s.data)
+		s.data = nd
+		return
+	}
+	s.data = s.data[:sz]
+}
+
+func (s *memStorage) size() (int64, error) {
+	return int64(len(s.data)), nil
+}
+
+func (s *memStorage) grow(sz int64) error {
+	if sz <= int64(len(s.data)) {
+		return nil
+	} else if s.ro {
+		return ErrReadOnly
+	}
+	s.extend(sz)
+	return nil
+}
+
+func (s *memStorage) mapRegion(off, sz int64, writable bool) (r region, err error) {
+	if off < 0 || off+sz > int64(len(s.data)) {
+		err = ErrInvalidBufferSize
+		return
+	} else if !writable {
+		//the data is not going to change underneath a read only region
+		r = &memRegion{off: off, b: s.data[off : off+sz : off+sz]}
+		return
+	} else if s.ro {
+		err = ErrReadOnly
+		return
+	}
+	mr := &memRegion{st: s, off: off, b: make([]byte, sz)}
+	s.ReadAt(mr.b, off)
+	s.regions = append(s.regions, mr)
+	r = mr
+	return
+}
+
+// mapData hands out the whole slice, appends that fit within its capacity show up in it
+func (s *memStorage) mapData(sz int64) (r region, err error) {
+	if sz > int64(len(s.data)) {
+		err = ErrInvalidBufferSize
+		return
+	}
+	r = &memRegion{b: s.data[:cap(s.data)]}
+	return
+}
+
+func (s *memStorage) sync() error {
+	return nil
+}
+
+func (s *memStorage) close() error {
+	for _, r := range s.regions {
+		copy(s.data[r.off:], r.b)
+	}
+	s.regions = nil
+	s.data = nil
+	return nil
+}
+
+type memRegion struct {
+	st  *memStorage //nil for regions that alias the data
+	off int64
+	b   []byte
+}
+
+func (r *memRegion) bytes() []byte {
+	return r.b
+}
+
+func (r *memRegion) sync(int64) error {
+	return nil
+}
+
+// unmap writes a region back into the slice
+func (r *memRegion) unmap() error {
+	if r.st == nil {
+		return nil
+	}
+	for i, v := range r.st.regions {
+		if v == r {
+			r.st.regions = append(r.st.regions[:i], r.st.regions[i+1:]...)
+			copy(r.st.data[r.off:], r.b)
+			break
+		}
+	}
+	return nil
+}
+
+// readerStorage serves a read only tilemap from an io.ReaderAt, regions are read into memory
+type readerStorage struct {
+	rdr    io.ReaderAt
+	sz     int64
+	closer io.Closer //closed with the storage if we opened the reader
+}
+
+func (s *readerStorage) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		err = ErrInvalidBufferSize
+		return
+	} else if off >= s.sz {
+		err = io.EOF
+		return
+	} else if rem := s.sz - off; int64(len(b)) > rem {
+		if n, err = s.rdr.ReadAt(b[:rem], off); err == nil {
+			err = io.EOF
+		}
+		return
+	}
+	return s.rdr.ReadAt(b, off)
+}
+
+func (s *readerStorage) WriteAt([]byte, int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (s *readerStorage) size() (int64, error) {
+	return s.sz, nil
+}
+
+func (s *readerStorage) grow(sz int64) error {
+	if sz > s.sz {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+func (s *readerStorage) mapRegion(off, sz int64, writable bool) (r region, err error) {
+	if writable {
+		err = ErrReadOnly
+		return
+	} else if off < 0 || off+sz > s.sz {
+		err = ErrInvalidBufferSize
+		return
+	}
+	mr := &memRegion{off: off, b: make([]byte, sz)}
+	if _, err = s.rdr.ReadAt(mr.b, off); err == io.EOF {
+		err = nil //some readers report EOF along with a read that reaches the end
+	}
+	if err == nil {
+		r = mr
+	}
+	return
+}
+
+// mapData reads the tilemap into memory, the size of a read only tilemap never changes
+func (s *readerStorage) mapData(sz int64) (region, error) {
+	return s.mapRegion(0, min(sz, s.sz), false)
+}
+
+func (s *readerStorage) sync() error {
+	return nil
+}
+
+func (s *readerStorage) close() (err error) {
+	if s.closer != nil {
+		err = s.closer.Close()
+	}
+	return
+}
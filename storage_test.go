@@ -0,0 +1,210 @@
+package tilemap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemoryTilemap(t *testing.T) {
+	for _, idx := range []IndexType{IndexDense, IndexSparse} {
+		t.Run(idx.String(), func(t *testing.T) {
+			testMemoryTilemap(t, idx)
+		})
+	}
+}
+
+func testMemoryTilemap(t *testing.T, idx IndexType) {
+	zl := 7
+	wtr, err := NewMemoryTilemap(nil, Config{Zoom: zl, Index: idx, Checksums: true, MapData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//enough tiles to grow the storage and the sparse table a few times
+	expected := make(map[uint64][]byte)
+	dim := 1 << zl
+	for i := 0; i < 5000; i++ {
+		x, y := i/dim, i%dim
+		buff := []byte(fmt.Sprintf("tile %d", i%1000))
+		if err = wtr.Add(x, y, buff); err != nil {
+			t.Fatal(err)
+		}
+		expected[tileid(zl, x, y)] = buff
+	}
+	if err = wtr.Delete(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	delete(expected, tileid(zl, 0, 0))
+	checkTiles(t, wtr, expected)
+	if view, err := wtr.GetTileView(0, 1); err != nil || string(view) != `tile 1` {
+		t.Fatalf("bad view %q %v", view, err)
+	}
+	if err = wtr.Compact(); err != nil {
+		t.Fatal(err)
+	} else if n, err := wtr.DeadBytes(); err != nil || n != 0 {
+		t.Fatalf("dead bytes after compact %d %v", n, err)
+	}
+	checkTiles(t, wtr, expected)
+
+	var bb bytes.Buffer
+	if n, err := wtr.WriteTo(&bb); err != nil {
+		t.Fatal(err)
+	} else if n != int64(bb.Len()) || n != wtr.size() {
+		t.Fatalf("bad WriteTo size %d %d %d", n, bb.Len(), wtr.size())
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the saved copy opens from memory, a reader, and a file
+	rdr, err := NewMemoryTilemap(bytes.Clone(bb.Bytes()), Config{Zoom: AnyZoom, ReadOnly: true, MapData: true, VerifyReads: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if rdr.Header().Dirty {
+		t.Fatal("saved copy is dirty")
+	}
+	checkTiles(t, rdr, expected)
+	if err = rdr.Add(0, 0, []byte(`nope`)); err != ErrReadOnly {
+		t.Fatalf("Failed to refuse a write: %v", err)
+	} else if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rdr, err = OpenReaderTilemap(bytes.NewReader(bb.Bytes()), int64(bb.Len()), Config{Zoom: AnyZoom, MapData: true}); err != nil {
+		t.Fatal(err)
+	}
+	checkTiles(t, rdr, expected)
+	if view, err := rdr.GetTileView(0, 2); err != nil || string(view) != `tile 2` {
+		t.Fatalf("bad reader view %q %v", view, err)
+	} else if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pth := filepath.Join(tdir, `memory`+idx.String())
+	if err = os.WriteFile(pth, bb.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	} else if rdr, err = OpenTilemap(pth, false); err != nil {
+		t.Fatal(err)
+	} else if rdr.Recovered() != 0 {
+		t.Fatal("saved copy needed recovery")
+	}
+	checkTiles(t, rdr, expected)
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageCorruption(t *testing.T) {
+	zl := 2
+	wtr, err := NewMemoryTilemap(nil, Config{Zoom: zl, Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Add(0, 0, []byte(`first`)); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(1, 1, []byte(`second`)); err != nil {
+		t.Fatal(err)
+	}
+	dp, err := wtr.getDataPointer(tileid(zl, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bb bytes.Buffer
+	if _, err = wtr.WriteTo(&bb); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//flip a bit in the second blob and point a third tile past the end
+	buff := bb.Bytes()
+	buff[dp.offset] ^= 0x1
+	dpoff := headerSize + int64(tileid(zl, 2, 2))*dpsize
+	if err = (&datapointer{offset: int64(len(buff)), size: 10}).Encode(buff[dpoff:]); err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewMemoryTilemap(buff, Config{Zoom: AnyZoom, ReadOnly: true, VerifyReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.GetTile(1, 1); err != ErrChecksum {
+		t.Fatalf("Failed to catch corrupt tile: %v", err)
+	} else if _, err = rdr.GetTile(2, 2); err != ErrTileNotFound {
+		t.Fatalf("Failed to hide unpublished tile: %v", err)
+	} else if buff, err := rdr.GetTile(0, 0); err != nil || string(buff) != `first` {
+		t.Fatalf("bad tile %q %v", buff, err)
+	}
+	if r, err := rdr.Verify(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	} else if len(r.BadChecksum) != 1 || r.BadChecksum[0].X != 1 || r.BadChecksum[0].Y != 1 {
+		t.Fatalf("bad verify %+v", r)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTilesetFS(t *testing.T) {
+	dir := filepath.Join(tdir, `tilesetfs`)
+	ts, err := OpenTileset(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for z := 0; z < 3; z++ {
+		if err = ts.Add(z, 0, 0, []byte(fmt.Sprintf("zoom %d", z))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cont := filepath.Join(tdir, `tilesetfs.pack`)
+	if err = PackTileset(dir, cont); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{}
+	for _, name := range []string{`0.tiles`, `1.tiles`, `2.tiles`} {
+		buff, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys[`tiles/`+name] = &fstest.MapFile{Data: buff}
+	}
+	buff, err := os.ReadFile(cont)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys[`tiles.pack`] = &fstest.MapFile{Data: buff}
+
+	for _, name := range []string{`tiles`, `tiles.pack`} {
+		ts, err := OpenTilesetFS(fsys, name, Config{MapData: true})
+		if err != nil {
+			t.Fatal(err)
+		} else if zooms := ts.Zooms(); len(zooms) != 3 {
+			t.Fatalf("%s has zooms %v", name, zooms)
+		}
+		for z := 0; z < 3; z++ {
+			if buff, err := ts.GetTile(z, 0, 0); err != nil || string(buff) != fmt.Sprintf("zoom %d", z) {
+				t.Fatalf("%s bad tile %q %v", name, buff, err)
+			} else if view, err := ts.GetTileView(z, 0, 0); err != nil || string(view) != string(buff) {
+				t.Fatalf("%s bad view %q %v", name, view, err)
+			}
+		}
+		if err = ts.Add(0, 0, 1, []byte(`nope`)); err == nil {
+			t.Fatal("Failed to refuse a write")
+		} else if err = ts.Refresh(); err != nil {
+			t.Fatal(err)
+		} else if err = ts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tm, err := OpenTilemapFS(fsys, `tiles/1.tiles`, Config{Zoom: AnyZoom})
+	if err != nil {
+		t.Fatal(err)
+	} else if buff, err := tm.GetTile(0, 0); err != nil || string(buff) != `zoom 1` {
+		t.Fatalf("bad tile %q %v", buff, err)
+	} else if err = tm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
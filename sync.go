@@ -0,0 +1,145 @@
+package tilemap
+
+import (
+	"time"
+)
+
+// Sync flushes tile data and then the index to disk
+func (w *Tilemap) Sync() (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.ro {
+		return
+	}
+	err = w.sync()
+	return
+}
+
+func (w *Tilemap) sync() (err error) {
+	if err = w.flush(); err != nil {
+		return
+	} else if err = w.st.sync(); err != nil {
+		err = errorLine(err)
+	} else if err = w.syncIndex(); err != nil {
+		err = errorLine(err)
+	} else {
+		w.unsynced = 0
+	}
+	return
+}
+
+// syncIndex flushes the header and index regions
+func (w *Tilemap) syncIndex() (err error) {
+	if err = w.hreg.sync(0); err == nil && w.ireg != nil {
+		err = w.ireg.sync(0)
+	}
+	return
+}
+
+// wrote accounts for n modifications and syncs if the durability settings call for it
+func (w *Tilemap) wrote(n int) (err error) {
+	w.unsynced += n
+	if w.syncEvery > 0 && w.unsynced >= w.syncEvery {
+		err = w.sync()
+	}
+	return
+}
+
+// Recover clears datapointers that reference data outside of the blob region and returns how many it cleared
+func (w *Tilemap) Recover() (n int64, err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.ro {
+		err = ErrReadOnly
+		return
+	} else if err = w.flush(); err != nil {
+		return
+	}
+	n, err = w.recover()
+	return
+}
+
+// Recovered returns the number of tiles cleared by recovery since the tilemap was opened
+func (w *Tilemap) Recovered() int64 {
+	w.RLock()
+	defer w.RUnlock()
+	return w.recovered
+}
+
+func (w *Tilemap) recover() (n int64, err error) {
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		if w.validDataPointer(dp) {
+			return nil
+		}
+		n++
+		return w.setDataPointer(tid, datapointer{})
+	})
+	if n > 0 {
+		w.recovered += n
+		if err == nil {
+			err = w.syncIndex()
+		}
+	}
+	return
+}
+
+// openForWrite runs recovery if the last writer did not close cleanly and marks the file dirty
+func (w *Tilemap) openForWrite() (err error) {
+	if w.hdr.Legacy() {
+		return
+	}
+	if w.hdr.Dirty {
+		w.resetIndexSeq()
+		if _, err = w.recover(); err != nil {
+			return
+		}
+	}
+	err = w.setDirty(true)
+	return
+}
+
+// setDirty updates the dirty flag in the header and flushes it
+func (w *Tilemap) setDirty(dirty bool) (err error) {
+	if w.hdr.Legacy() || w.hdr.Dirty == dirty {
+		return
+	}
+	hdr := w.hdr
+	hdr.Dirty = dirty
+	if err = hdr.Encode(w.mm); err != nil {
+		return
+	} else if err = w.hreg.sync(headerSize); err != nil {
+		return
+	}
+	w.hdr = hdr
+	return
+}
+
+func (w *Tilemap) startSync(interval time.Duration) {
+	w.syncStop = make(chan es)
+	w.syncWg.Add(1)
+	go func() {
+		defer w.syncWg.Done()
+		tckr := time.NewTicker(interval)
+		defer tckr.Stop()
+		for {
+			select {
+			case <-tckr.C:
+				w.Lock()
+				if w.unsynced > 0 {
+					w.sync() //failures are retried on the next tick and surface on Close
+				}
+				w.Unlock()
+			case <-w.syncStop:
+				return
+			}
+		}
+	}()
+}
+
+func (w *Tilemap) stopSync() {
+	if w.syncStop != nil {
+		close(w.syncStop)
+		w.syncWg.Wait()
+		w.syncStop = nil
+	}
+}
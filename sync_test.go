@@ -0,0 +1,60 @@
+package tilemap
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTilemapRecovery(t *testing.T) {
+	zl := 2
+	pth := filepath.Join(tdir, `recovery`)
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, SyncEvery: 2})
+	if err != nil {
+		t.Fatal(err)
+	} else if !wtr.Header().Dirty {
+		t.Fatal("open writer did not mark the header dirty")
+	}
+	if err = wtr.Add(0, 0, []byte(`good`)); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(0, 1, []byte(`also good`)); err != nil {
+		t.Fatal(err)
+	} else if wtr.unsynced != 0 {
+		t.Fatalf("SyncEvery did not sync: %d", wtr.unsynced)
+	}
+
+	//simulate the index reaching disk without the data, then crash without a Close
+	if err = wtr.setDataPointer(tileid(zl, 1, 1), datapointer{offset: wtr.foff, size: 100}); err != nil {
+		t.Fatal(err)
+	}
+	wtr.hreg.unmap()
+	wtr.st.close()
+
+	if h, err := ReadHeader(pth); err != nil {
+		t.Fatal(err)
+	} else if !h.Dirty {
+		t.Fatal("crashed file is not dirty")
+	}
+	if wtr, err = NewTilemap(pth, zl, false); err != nil {
+		t.Fatal(err)
+	} else if n := wtr.Recovered(); n != 1 {
+		t.Fatalf("recovery cleared %d tiles", n)
+	}
+	if _, err = wtr.GetTile(1, 1); err != ErrTileNotFound {
+		t.Fatalf("Failed to clear bad datapointer: %v", err)
+	}
+	if buff, err := wtr.GetTile(0, 1); err != nil {
+		t.Fatal(err)
+	} else if string(buff) != `also good` {
+		t.Fatalf("bad tile %q", buff)
+	}
+	if err = wtr.Sync(); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := ReadHeader(pth); err != nil {
+		t.Fatal(err)
+	} else if h.Dirty {
+		t.Fatal("cleanly closed file is dirty")
+	}
+}
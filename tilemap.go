@@ -1,15 +1,16 @@
 package tilemap
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
 	"runtime"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/dchest/siphash"
-	"github.com/tysontate/gommap"
 )
 
 const (
@@ -18,12 +19,8 @@ const (
 	maxMapInitSize = 1024 * 1024 * 16 //start at 16mil
 	maxTileSize    = 1 << 24          // like 4MB whichi si stupid
 
-	MaxZoom = maxDimension
+	MaxZoom = 22 //sparse index keys hold 48 bit tile ids
 
-	wtrMapFlags  = gommap.PROT_WRITE | gommap.PROT_READ
-	wtrOpenFlags = os.O_RDWR | os.O_CREATE
-	rdrMapFlags  = gommap.PROT_READ
-	rdrOpenFlags = os.O_RDONLY
 )
 
 var (
@@ -34,247 +31,787 @@ var (
 	ErrInvalidDatapointer  = errors.New("invalid tile datapointer, file may be corrupt")
 	ErrInvalidDPRegionSize = errors.New("invalid tile datapointer region size, file may be corrupt")
 	ErrPartialWrite        = errors.New("Partial write")
+	ErrTileNotFound        = errors.New("tile not found")
+	ErrReadOnly            = errors.New("tilemap is read only")
 )
 
 type Tilemap struct {
 	sync.RWMutex
-	ro   bool
-	zoom int
-	hmp  map[uint64]uint32
-	fio  *os.File
-	mm   gommap.MMap
-	foff int64
+	ro     bool
+	zoom   int
+	hdr    Header
+	pth    string
+	hmp    map[uint64]datapointer   //content hash to blob
+	hcol   map[uint64][]datapointer //blobs whose hash was already taken in hmp
+	refs   map[int64]uint32         //blob offset to reference count
+	live   int64                    //bytes held by referenced blobs
+	hash   HashFunc
+	st     storage
+	hreg   region //header region, holds the dense index too
+	mm     []byte
+	idx    []byte //datapointer region
+	ireg   region //sparse index region
+	used   int64  //sparse index slots in use
+	verify bool   //check blob checksums on read
+
+	//buffered writes, blobs collect in wbuf and datapointers in pending until a flush
+	wbufSize  int
+	batching  bool
+	wbuf      []byte
+	wbase     int64 //file offset of wbuf
+	pending   map[uint64]datapointer
+	dataStart int64
+	foff      int64
+
+	//optional read only mapping of the entire file for zero copy reads
+	mapped  bool
+	vmtx    sync.RWMutex //held while views are in use outside the tilemap lock
+	dreg    region
+	dmm     []byte
+	oldMaps []region //retained until Close so outstanding views stay valid
+
+	refreshWg   sync.WaitGroup
+	refreshStop chan es
+
+	syncEvery int
+	unsynced  int
+	recovered int64
+	syncWg    sync.WaitGroup
+	syncStop  chan es
+}
+
+// Config controls how a tilemap file is opened or created.
+// MimeType, TileSize, and Metadata are only used when creating a new file.
+type Config struct {
+	Zoom     int //use AnyZoom to take the zoom from the file header
+	ReadOnly bool
+	MimeType string
+	TileSize int
+	Metadata Metadata
+	Hash     HashFunc  //content hash used for deduplication, defaults to siphash
+	MapData  bool      //map the entire file so tiles can be read without copying
+	Index    IndexType //indexes covering more tiles than zoom 16 always use IndexSparse
+
+	Checksums   bool //store a CRC32C after each blob, only used when creating a new file
+	VerifyReads bool //check the checksum of every tile read from a file that has them
+
+	//tile compression, only used when creating a new file.  Sizes reported by TileSize,
+	//Walk, and Stats are the stored sizes.
+	Compression Compression
+
+	//tile id ordering within the index, only used when creating a new file
+	Curve Curve
+
+	//format of the tiles, only used when creating a new file.  When set the MIME type defaults
+	//to the one for the format and every tile added is sniffed to make sure it matches.
+	Format Format
+
+	//window of tiles to index, only used when creating a new file.  Tiles outside the
+	//region cannot be added and are reported as not found.
+	Region Region
+
+	//buffer up to WriteBuffer bytes of new blobs before writing them out, tiles become visible
+	//to other processes when the buffer is flushed by filling up, Sync, or Close
+	WriteBuffer int
+
+	//poll for tiles appended by another process, only used by read only tilemaps
+	RefreshInterval time.Duration
+
+	//durability for writers, Sync after every SyncEvery adds and/or every SyncInterval
+	SyncEvery    int
+	SyncInterval time.Duration
+}
+
+// HashFunc produces the content hash used to find duplicate tiles.
+// Matches are always confirmed by comparing the stored bytes.
+type HashFunc func([]byte) uint64
+
+func sipHash(b []byte) uint64 {
+	return siphash.Hash(sipkey1, sipkey2, b)
 }
 
 func NewTilemap(pth string, zoom int, ro bool) (w *Tilemap, err error) {
-	if zoom < 0 || zoom > maxDimension {
+	if zoom < 0 || zoom > MaxZoom {
+		err = ErrInvalidDimension
+		return
+	}
+	return NewTilemapConfig(pth, Config{Zoom: zoom, ReadOnly: ro})
+}
+
+// OpenTilemap opens an existing tilemap file using the zoom stored in its header
+func OpenTilemap(pth string, ro bool) (w *Tilemap, err error) {
+	return NewTilemapConfig(pth, Config{Zoom: AnyZoom, ReadOnly: ro})
+}
+
+func NewTilemapConfig(pth string, c Config) (w *Tilemap, err error) {
+	if c.Zoom < AnyZoom || c.Zoom > MaxZoom {
 		err = ErrInvalidDimension
 		return
 	} else if pth == `` {
 		err = errors.New("invalid path")
 		return
 	}
-	var oflags int
-	var mapflags gommap.ProtFlags
-
-	if ro {
-		oflags = rdrOpenFlags
-		mapflags = rdrMapFlags
-	} else {
-		oflags = wtrOpenFlags
-		mapflags = wtrMapFlags
-
+	var st *fileStorage
+	if st, err = openFileStorage(pth, c.ReadOnly); err != nil {
+		return
+	}
+	if w, err = newTilemap(st, c); err != nil {
+		st.close()
+		return
 	}
+	w.pth = pth
+	if c.ReadOnly && c.RefreshInterval > 0 {
+		w.startRefresh(c.RefreshInterval)
+	} else if !c.ReadOnly && c.SyncInterval > 0 {
+		w.startSync(c.SyncInterval)
+	}
+	return
+}
 
-	var fio *os.File
-	var mm gommap.MMap
-	tc := tileCount(zoom)
-	dpRegionSize := int64(tc * dpsize)
-	if fio, err = os.OpenFile(pth, oflags, 0640); err != nil {
+// newTilemap maps the header and index of a tilemap held in st
+func newTilemap(st storage, c Config) (w *Tilemap, err error) {
+	var size int64
+	if size, err = st.size(); err != nil {
+		return
+	}
+	var hdr Header
+	if hdr, err = loadHeader(st, size, c); err != nil {
 		return
 	}
-	//best effort, may not be supported
-	setAttr(fio, NO_COW)
+	zoom := hdr.Zoom
+	hdrSize := hdr.size()
+	idxEnd := hdr.indexOffset() + hdr.indexSize()
 
-	// if not in readonly mode, prep the file
-	if !ro {
-		if _, err = prepFileMap(fio, zoom); err != nil {
-			fio.Close()
+	// if not in readonly mode, prep the storage
+	if !c.ReadOnly {
+		if size, err = prepStorage(st, idxEnd); err != nil {
 			return
 		}
-	} else {
-		if _, err = checkFileMap(fio, zoom); err != nil {
-			fio.Close()
+	}
+	if size < idxEnd {
+		err = ErrInvalidDPRegionSize
+		return
+	}
+	//the dense index is mapped with the header, sparse tables are mapped on their own
+	mapSize := idxEnd
+	if hdr.Index == IndexSparse {
+		mapSize = hdrSize
+	}
+	var hreg region
+	if hreg, err = st.mapRegion(0, mapSize, !c.ReadOnly); err != nil {
+		return
+	}
+	if c.Hash == nil {
+		c.Hash = sipHash
+	}
+	mm := hreg.bytes()
+	w = &Tilemap{
+		ro:        c.ReadOnly,
+		hash:      c.Hash,
+		zoom:      zoom,
+		hdr:       hdr,
+		st:        st,
+		hreg:      hreg,
+		mm:        mm,
+		idx:       mm[hdrSize:],
+		dataStart: hdr.dataStart(),
+		foff:      size,
+		mapped:    c.MapData,
+		syncEvery: c.SyncEvery,
+		verify:    c.VerifyReads,
+		wbufSize:  c.WriteBuffer,
+	}
+	if hdr.Index == IndexSparse {
+		if err = w.mapIndex(hdr); err != nil {
+			hreg.unmap()
+			w = nil
+			return
+		}
+	}
+	if !w.ro {
+		if err = w.openForWrite(); err != nil {
+			w.unmap()
+			w = nil
 			return
 		}
 	}
-	if mm, err = gommap.MapRegion(fio.Fd(), 0, dpRegionSize, mapflags, gommap.MAP_SHARED); err != nil {
-		fio.Close()
+	if w.mapped {
+		if err = w.mapData(); err != nil {
+			w.unmap()
+			w = nil
+		}
+	}
+	return
+}
+
+// unmap releases the header and index regions
+func (w *Tilemap) unmap() (err error) {
+	if w.ireg != nil {
+		err = w.ireg.unmap()
+		w.ireg = nil
 	}
-	var foff int64
-	if foff, err = fio.Seek(0, os.SEEK_END); err != nil {
-		mm.UnsafeUnmap()
-		fio.Close()
+	if lerr := w.hreg.unmap(); lerr != nil {
+		err = lerr
+	}
+	return
+}
+
+// Header returns the tilemap file header, legacy files return a header with a zero Version
+func (w *Tilemap) Header() Header {
+	w.RLock()
+	defer w.RUnlock()
+	return w.hdr
+}
+
+// Zoom returns the zoom level of the tilemap
+func (w *Tilemap) Zoom() int {
+	return w.zoom
+}
+
+// SetMetadata updates the metadata block in the file header
+func (w *Tilemap) SetMetadata(md Metadata) (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.ro {
+		err = ErrReadOnly
 		return
-	} else if foff < dpRegionSize {
-		mm.UnsafeUnmap()
-		fio.Close()
-		err = ErrInvalidDPRegionSize
+	} else if w.hdr.Legacy() {
+		err = ErrNoHeader
 		return
 	}
-	w = &Tilemap{
-		ro:   ro,
-		zoom: zoom,
-		fio:  fio,
-		mm:   mm,
-		foff: foff,
+	hdr := w.hdr
+	hdr.Metadata = md
+	if err = hdr.Encode(w.mm); err == nil {
+		w.hdr = hdr
 	}
 	return
 }
 
-func (w *Tilemap) initHashMap() {
-	mapInitSize := tileCount(w.zoom)
+// initHashMap builds the deduplication map and blob reference counts, any blobs already
+// in the file are hashed so that a reopened tilemap keeps deduplicating against existing tiles
+func (w *Tilemap) initHashMap() (err error) {
+	mapInitSize := w.hdr.tileCount()
+	if w.hdr.Index == IndexSparse {
+		mapInitSize = w.used
+	}
 	if mapInitSize > maxMapInitSize {
 		mapInitSize = maxMapInitSize
 	}
-	w.hmp = make(map[uint64]uint32, mapInitSize)
+	hmp := make(map[uint64]datapointer, mapInitSize)
+	hcol := make(map[uint64][]datapointer)
+	refs := make(map[int64]uint32, mapInitSize)
+	var live int64
+
+	//gather the unique blobs, then read them in file order
+	var blobs []datapointer
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		if !w.validDataPointer(dp) {
+			return nil
+		}
+		if refs[dp.offset] == 0 {
+			blobs = append(blobs, dp)
+			live += w.extent(dp)
+		}
+		refs[dp.offset]++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].offset < blobs[j].offset
+	})
+	var buff []byte
+	for _, dp := range blobs {
+		if int64(cap(buff)) < dp.size {
+			buff = make([]byte, dp.size)
+		}
+		buff = buff[:dp.size]
+		if err = w.readAt(buff, dp.offset); err != nil {
+			err = errorLine(err)
+			return
+		}
+		//dedup runs on the original tiles
+		var tile []byte
+		if tile, err = w.decode(buff); err != nil {
+			return
+		}
+		key := w.hash(tile)
+		if _, ok := hmp[key]; !ok {
+			hmp[key] = dp
+		} else {
+			hcol[key] = append(hcol[key], dp)
+		}
+	}
+	w.hmp = hmp
+	w.hcol = hcol
+	w.refs = refs
+	w.live = live
+	return
+}
+
+// walkIndex calls fn for every populated datapointer in the index.
+// Dense indexes are walked in tile id order, sparse indexes in table order.
+func (w *Tilemap) walkIndex(fn func(tid uint64, dp datapointer) error) (err error) {
+	if len(w.pending) > 0 {
+		return w.walkPending(fn)
+	}
+	return w.walkStored(fn)
+}
+
+// walkStored calls fn for every populated datapointer written to the index
+func (w *Tilemap) walkStored(fn func(tid uint64, dp datapointer) error) (err error) {
+	if w.hdr.Index == IndexSparse {
+		return w.walkSparse(fn)
+	}
+	var dp datapointer
+	tc := w.hdr.tileCount()
+	for tid := int64(0); tid < tc; tid++ {
+		w.readIndex(func() {
+			dp = loadDataPointer(w.idx, tid*dpsize)
+		})
+		if dp.size == 0 {
+			continue //not populated
+		}
+		if err = fn(uint64(tid), dp); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// count returns the number of populated tiles
+func (w *Tilemap) count() (n int64) {
+	w.RLock()
+	defer w.RUnlock()
+	w.walkIndex(func(tid uint64, dp datapointer) error {
+		n++
+		return nil
+	})
+	return
+}
+
+// size returns the total number of bytes used by the tilemap
+func (w *Tilemap) size() int64 {
+	w.RLock()
+	defer w.RUnlock()
+	return w.foff
+}
+
+// validDataPointer checks that a datapointer and its checksum reference data within the blob region
+func (w *Tilemap) validDataPointer(dp datapointer) bool {
+	return dp.offset >= w.dataStart && (dp.offset+w.extent(dp)) <= w.foff
 }
 
 func (w *Tilemap) Add(x, y int, buff []byte) (err error) {
 	w.Lock()
 	defer w.Unlock()
-	var dp datapointer
-	bsize := len(buff)
-	mid := 1 << uint(w.zoom)
-	tid := w.tileid(x, y)
-	if x < 0 || x >= mid || y < 0 || y >= mid {
-		err = fmt.Errorf("%v %d %d", errorLine(ErrInvalidTileID), x, y)
+	if w.ro {
+		err = ErrReadOnly
 		return
-	} else if bsize == 0 || bsize > maxTileSize {
-		err = errorLine(ErrInvalidTileBuffer)
+	} else if err = w.checkTile(x, y, buff); err != nil {
 		return
 	}
+	if err = w.add(x, y, buff); err == nil {
+		err = w.wrote(1)
+	}
+	return
+}
+
+// checkTile validates the coordinates and contents of a tile that is about to be added
+func (w *Tilemap) checkTile(x, y int, buff []byte) (err error) {
+	if !w.validTile(x, y) {
+		err = fmt.Errorf("%v %d %d", errorLine(ErrInvalidTileID), x, y)
+	} else if !w.hdr.Region.Contains(x, y) {
+		err = fmt.Errorf("%v %d %d", errorLine(ErrOutsideRegion), x, y)
+	} else if bsize := len(buff); bsize == 0 || bsize > maxTileSize {
+		err = errorLine(ErrInvalidTileBuffer)
+	} else if !w.hdr.Format.Match(buff) {
+		err = ErrFormatMismatch
+	}
+	return
+}
+
+// add deduplicates and stores a validated tile, caller must hold the write lock
+func (w *Tilemap) add(x, y int, buff []byte) (err error) {
+	var dp datapointer
+	var ok bool
+	tid := w.tileid(x, y)
 	if w.hmp == nil {
 		//we are going to be writing, so go ahead and init the hash map
-		w.initHashMap()
+		if err = w.initHashMap(); err != nil {
+			err = errorLine(err)
+			return
+		}
 	}
 	//hash the buffer and check if we know about it
-	key := siphash.Hash(sipkey1, sipkey2, buff)
-	if extid, ok := w.hmp[key]; ok {
-		if dp, err = w.getDataPointer(extid); err != nil {
+	key := w.hash(buff)
+	if dp, ok, err = w.findBlob(key, buff); err != nil {
+		err = errorLine(err)
+		return
+	} else if !ok {
+		//no duplicate, write it out
+		var stored []byte
+		if stored, err = w.hdr.Compression.compress(buff); err != nil {
 			err = errorLine(err)
 			return
-		}
-	} else {
-		//no hash collision, write it out
-		if dp, err = w.writeNewBuffer(buff); err != nil {
+		} else if dp, err = w.writeNewBuffer(stored); err != nil {
 			err = errorLine(err)
 			return
 		}
-		w.hmp[key] = tid //add to our collision map
+		if _, collision := w.hmp[key]; !collision {
+			w.hmp[key] = dp //add to our dedup map
+		} else {
+			w.hcol[key] = append(w.hcol[key], dp)
+		}
 	}
-	if err = w.setDataPointer(tid, dp); err != nil {
+	if err = w.replaceDataPointer(tid, dp); err != nil {
 		err = fmt.Errorf("Failed to set datapointer for %d/%d: %v", x, y, err)
 	}
 	return
 }
 
+// Delete removes the tile at x, y, the blob is left in place until the tilemap is compacted
+func (w *Tilemap) Delete(x, y int) (err error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.ro {
+		err = ErrReadOnly
+		return
+	} else if _, err = w.lookup(x, y); err != nil {
+		return
+	}
+	if w.hmp == nil {
+		if err = w.initHashMap(); err != nil {
+			err = errorLine(err)
+			return
+		}
+	}
+	if err = w.replaceDataPointer(w.tileid(x, y), datapointer{}); err != nil {
+		err = fmt.Errorf("Failed to clear datapointer for %d/%d: %v", x, y, err)
+		return
+	}
+	err = w.wrote(1)
+	return
+}
+
+// replaceDataPointer sets a datapointer and updates the blob reference counts.
+// A zero datapointer clears the tile.  Buffered writers hold the datapointer until the flush.
+func (w *Tilemap) replaceDataPointer(tid uint64, dp datapointer) (err error) {
+	var old datapointer
+	if old, err = w.getDataPointer(tid); err != nil {
+		return
+	}
+	if w.buffering() {
+		if w.pending == nil {
+			w.pending = make(map[uint64]datapointer)
+		}
+		w.pending[tid] = dp
+	} else if err = w.setDataPointer(tid, dp); err != nil {
+		return
+	}
+	if dp.size > 0 {
+		w.ref(dp)
+	}
+	if old.size > 0 && w.validDataPointer(old) {
+		w.unref(old)
+	}
+	return
+}
+
+func (w *Tilemap) ref(dp datapointer) {
+	if w.refs[dp.offset] == 0 {
+		w.live += w.extent(dp)
+	}
+	w.refs[dp.offset]++
+}
+
+func (w *Tilemap) unref(dp datapointer) {
+	if cnt := w.refs[dp.offset]; cnt > 1 {
+		w.refs[dp.offset] = cnt - 1
+	} else if cnt == 1 {
+		delete(w.refs, dp.offset)
+		w.live -= w.extent(dp)
+	}
+}
+
+// writeNewBuffer appends a blob followed by its checksum if the file carries them
 func (w *Tilemap) writeNewBuffer(buff []byte) (dp datapointer, err error) {
+	if dp, err = w.writeBlob(buff, int64(len(buff))); err == nil && w.hdr.Checksums {
+		var crc [checksumSize]byte
+		binary.LittleEndian.PutUint32(crc[:], checksum(buff))
+		_, err = w.writeBlob(crc[:], 0)
+	}
+	return
+}
+
+// writeBlob appends raw bytes to the blob region, size is the blob portion of buff.
+// Buffered writers collect the bytes in memory until the buffer fills or the tilemap is synced.
+func (w *Tilemap) writeBlob(buff []byte, size int64) (dp datapointer, err error) {
+	if w.buffering() {
+		if len(w.wbuf) == 0 {
+			w.wbase = w.foff
+		}
+		w.wbuf = append(w.wbuf, buff...)
+		dp.offset = w.foff
+		dp.size = size
+		w.foff += int64(len(buff))
+		if len(w.wbuf) >= w.bufferLimit() {
+			err = w.flush()
+		}
+		return
+	}
 	var n int
-	if n, err = w.fio.Write(buff); err != nil {
+	if n, err = w.st.WriteAt(buff, w.foff); err != nil {
 		return
 	} else if n != len(buff) {
 		err = errorLine(ErrPartialWrite)
 		return
 	}
 	dp.offset = w.foff
-	dp.size = int64(len(buff))
-	w.foff += dp.size
+	dp.size = size
+	w.foff += int64(len(buff))
+	if w.mapped && w.foff > int64(len(w.dmm)) {
+		err = w.mapData()
+	}
 	return
 }
 
-func (w *Tilemap) getDataPointer(tid uint32) (dp datapointer, err error) {
+// findBlob returns a stored blob holding buff.  A matching hash is not proof, so every blob
+// with the hash is compared against buff.
+func (w *Tilemap) findBlob(key uint64, buff []byte) (dp datapointer, ok bool, err error) {
+	if dp, ok = w.hmp[key]; !ok {
+		return
+	} else if ok, err = w.blobEqual(dp, buff); ok || err != nil {
+		return
+	}
+	for _, dp = range w.hcol[key] {
+		if ok, err = w.blobEqual(dp, buff); ok || err != nil {
+			return
+		}
+	}
+	return
+}
+
+// blobEqual checks if the blob referenced by a datapointer holds the tile in buff
+func (w *Tilemap) blobEqual(dp datapointer, buff []byte) (ok bool, err error) {
+	if !w.validDataPointer(dp) {
+		return
+	} else if w.hdr.Compression == CompressNone && dp.size != int64(len(buff)) {
+		return
+	}
+	sbuff := make([]byte, dp.size)
+	if err = w.readAt(sbuff, dp.offset); err != nil {
+		return
+	} else if sbuff, err = w.decode(sbuff); err != nil {
+		return
+	}
+	ok = bytes.Equal(sbuff, buff)
+	return
+}
+
+// decode returns the original tile from its stored form
+func (w *Tilemap) decode(b []byte) (r []byte, err error) {
+	if r, err = w.hdr.Compression.decompress(b); err != nil {
+		r = nil
+		err = errorLine(err)
+	}
+	return
+}
+
+// readAt fills buff from the tilemap at the given offset
+func (w *Tilemap) readAt(buff []byte, off int64) (err error) {
+	if w.buffered(off) {
+		copy(buff, w.wbuf[off-w.wbase:])
+		return
+	}
+	if end := off + int64(len(buff)); end <= int64(len(w.dmm)) && off >= 0 {
+		copy(buff, w.dmm[off:end])
+		return
+	}
+	_, err = w.st.ReadAt(buff, off)
+	return
+}
+
+func (w *Tilemap) getDataPointer(tid uint64) (dp datapointer, err error) {
+	if pdp, ok := w.pending[tid]; ok {
+		return pdp, nil
+	}
+	if w.hdr.Index == IndexSparse {
+		return w.getSparse(tid)
+	}
 	toff := int64(tid) * dpsize
-	if (toff + 6) > int64(len(w.mm)) {
+	if (toff + 6) > int64(len(w.idx)) {
 		err = errorLine(ErrInvalidTileID)
-		err = fmt.Errorf("%v %d > %d", err, (toff + 6), len(w.mm))
+		err = fmt.Errorf("%v %d > %d", err, (toff + 6), len(w.idx))
 	} else {
-		if err = dp.Decode(w.mm[toff:]); err != nil {
-			err = errorLine(err)
-		}
+		w.readIndex(func() {
+			dp = loadDataPointer(w.idx, toff)
+		})
 	}
 	return
 }
 
-func (w *Tilemap) setDataPointer(tid uint32, dp datapointer) (err error) {
+func (w *Tilemap) setDataPointer(tid uint64, dp datapointer) (err error) {
+	if w.hdr.Index == IndexSparse {
+		return w.setSparse(tid, dp)
+	}
 	toff := int64(tid) * dpsize
-	if (toff + 6) > int64(len(w.mm)) {
-		err = fmt.Errorf("%v %d %d > %d", errorLine(ErrInvalidTileID), tid, (toff + 6), len(w.mm))
+	if (toff + 6) > int64(len(w.idx)) {
+		err = fmt.Errorf("%v %d %d > %d", errorLine(ErrInvalidTileID), tid, (toff + 6), len(w.idx))
 	} else {
-		if err = dp.Encode(w.mm[toff:]); err != nil {
-			err = errorLine(err)
-		}
+		w.writeIndex(func() {
+			storeDataPointer(w.idx, toff, dp)
+		})
 	}
 	return
 }
 
+// GetTile returns the tile at x, y.  ErrTileNotFound is returned if the tile was never written
+// or is outside the tilemap Region, and ErrInvalidTileID is returned if x, y is outside the map.
 func (w *Tilemap) GetTile(x, y int) (buff []byte, err error) {
-	var dp datapointer
 	w.RLock()
 	defer w.RUnlock()
-	tid := w.tileid(x, y)
-	if dp, err = w.getDataPointer(tid); err != nil {
-		err = errorLine(err)
-		return
+	if buff, err = w.getRaw(x, y); err == nil {
+		buff, err = w.decode(buff)
 	}
+	return
+}
+
+// getRaw reads the stored form of the tile at x, y, caller must hold the read lock
+func (w *Tilemap) getRaw(x, y int) (buff []byte, err error) {
+	var dp datapointer
+	if dp, err = w.lookup(x, y); err == nil {
+		buff, err = w.readStored(dp)
+	}
+	return
+}
+
+// readStored reads the stored form of the blob at dp, caller must hold the read lock
+func (w *Tilemap) readStored(dp datapointer) (buff []byte, err error) {
 	//check that the bounds of the buffer are valid
-	buffStart := dp.offset
-	buffEnd := dp.offset + dp.size
-	if buffStart < int64(len(w.mm)) || buffEnd > w.foff {
-		err = errorLine(fmt.Errorf("%v %x:%x %x:%x %v",
-			buffStart, buffEnd, len(w.mm), w.foff, ErrInvalidDatapointer))
+	if !w.validDataPointer(dp) {
+		err = errorLine(fmt.Errorf("%v %x:%x %x:%x",
+			ErrInvalidDatapointer, dp.offset, dp.offset+dp.size, w.dataStart, w.foff))
 	} else {
 		buff = make([]byte, dp.size)
-		if _, err = w.fio.ReadAt(buff, dp.offset); err != nil {
+		if err = w.readAt(buff, dp.offset); err != nil {
 			buff = nil
 			err = errorLine(err)
+		} else if err = w.verifyRead(dp, buff); err != nil {
+			buff = nil
 		}
 	}
 	return
 }
 
-func (w *Tilemap) Close() (err error) {
-	w.hmp = nil
-	if err = w.mm.UnsafeUnmap(); err != nil {
-		w.fio.Close()
-		err = errorLine(err)
-	} else {
-		if err = w.fio.Close(); err != nil {
-			err = errorLine(err)
-		}
+// Has returns true if the tile at x, y is populated
+func (w *Tilemap) Has(x, y int) bool {
+	w.RLock()
+	defer w.RUnlock()
+	_, err := w.lookup(x, y)
+	return err == nil
+}
+
+// TileSize returns the size in bytes of the tile at x, y without reading it
+func (w *Tilemap) TileSize(x, y int) (sz int64, err error) {
+	var dp datapointer
+	w.RLock()
+	defer w.RUnlock()
+	if dp, err = w.lookup(x, y); err == nil {
+		sz = dp.size
 	}
 	return
 }
 
-func (w *Tilemap) tileid(x, y int) uint32 {
-	return tileid(w.zoom, x, y)
+// lookup retrieves the datapointer for a populated tile, caller must hold the lock
+func (w *Tilemap) lookup(x, y int) (dp datapointer, err error) {
+	if !w.validTile(x, y) {
+		err = ErrInvalidTileID
+	} else if !w.hdr.Region.Contains(x, y) {
+		err = ErrTileNotFound
+	} else if dp, err = w.getDataPointer(w.tileid(x, y)); err != nil {
+		err = errorLine(err)
+	} else if !w.published(dp) {
+		err = ErrTileNotFound
+	}
+	return
 }
 
-func tileid(zoom, x, y int) uint32 {
-	dim := int(1 << uint(zoom))
-	if dim == 1 {
-		return 0 //zoom of zero only has one tile, this is a special case
+// published returns true if the datapointer is populated and visible to this tilemap
+func (w *Tilemap) published(dp datapointer) bool {
+	if dp.size == 0 {
+		return false
+	} else if w.ro && dp.offset >= w.dataStart && dp.offset+w.extent(dp) > w.foff {
+		return false //published by a concurrent writer after our last Refresh
 	}
-	return uint32(x*dim + y)
+	return true
+}
+
+func (w *Tilemap) validTile(x, y int) bool {
+	dim := 1 << uint(w.zoom)
+	return x >= 0 && x < dim && y >= 0 && y < dim
 }
 
-func prepFileMap(fio *os.File, d int) (r int64, err error) {
-	var fi os.FileInfo
-	tc := tileCount(d)
-	sz := int64(dpsize * tc)
-	if fi, err = fio.Stat(); err != nil {
+func (w *Tilemap) Close() (err error) {
+	w.stopRefresh()
+	w.stopSync()
+	w.hmp = nil
+	w.hcol = nil
+	w.refs = nil
+	if !w.ro {
+		//flush everything and mark the file as cleanly closed
+		if err = w.sync(); err == nil {
+			err = w.setDirty(false)
+		}
+	}
+	if w.mapped {
+		w.unmapData()
+	}
+	if err != nil {
+		w.unmap()
+		w.st.close()
 		err = errorLine(err)
 		return
 	}
-	if fi.Size() < sz {
-		if err = safeFallocate(fio, fi.Size(), sz); err == nil {
-			r = sz
-		}
-	} else {
-		r = fi.Size()
+	if err = w.unmap(); err != nil {
+		w.st.close()
+		err = errorLine(err)
+	} else if err = w.st.close(); err != nil {
+		err = errorLine(err)
 	}
 	return
 }
 
-func checkFileMap(fio *os.File, d int) (r int64, err error) {
-	var fi os.FileInfo
-	tc := tileCount(d)
-	sz := int64(dpsize * tc)
-	if fi, err = fio.Stat(); err != nil {
-		return
+func (w *Tilemap) tileid(x, y int) uint64 {
+	return w.hdr.Region.tileid(w.hdr.Curve, w.zoom, x, y)
+}
+
+func (w *Tilemap) tilexy(tid uint64) (x, y int) {
+	return w.hdr.Region.tilexy(w.hdr.Curve, w.zoom, tid)
+}
+
+// tileid is the row major tile id used by legacy files
+func tileid(zoom, x, y int) uint64 {
+	dim := int(1 << uint(zoom))
+	if dim == 1 {
+		return 0 //zoom of zero only has one tile, this is a special case
 	}
-	if fi.Size() < sz {
-		err = errors.New("File map is undersized")
+	return uint64(x*dim + y)
+}
+
+// tilexy is the inverse of tileid
+func tilexy(zoom int, tid uint64) (x, y int) {
+	dim := uint64(1) << uint(zoom)
+	return int(tid / dim), int(tid % dim)
+}
+
+// prepStorage ensures the storage is at least sz bytes, returning its size
+func prepStorage(st storage, sz int64) (r int64, err error) {
+	if err = st.grow(sz); err != nil {
+		err = errorLine(err)
+	} else if r, err = st.size(); err != nil {
+		err = errorLine(err)
 	}
 	return
 }
@@ -313,6 +850,21 @@ func (dp *datapointer) Encode(b []byte) (err error) {
 	return
 }
 
+// loadDataPointer reads the datapointer at off in an index that may be shared with a writer
+func loadDataPointer(idx []byte, off int64) (dp datapointer) {
+	var b [dpsize]byte
+	loadShared(b[:], idx, int(off))
+	dp.Decode(b[:])
+	return
+}
+
+// storeDataPointer writes the datapointer at off in an index that may be shared with readers
+func storeDataPointer(idx []byte, off int64, dp datapointer) {
+	var b [dpsize]byte
+	dp.Encode(b[:])
+	storeShared(idx, int(off), b[:])
+}
+
 func errorLine(err error) error {
 	_, fname, line, _ := runtime.Caller(1)
 	return fmt.Errorf("%s:%d %v", fname, line, err)
@@ -64,7 +64,7 @@ func TestEncodeDecode(t *testing.T) {
 }
 
 func TestNewTilemap(t *testing.T) {
-	wtr, err := NewTilemap(filepath.Join(tdir, `test`), 2, false)
+	wtr, err := NewTilemap(filepath.Join(tdir, `newtest`), 2, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -103,7 +103,7 @@ func TestTilemap(t *testing.T) {
 
 func TestTilemapHighCollision(t *testing.T) {
 	zl := 8
-	wtr, err := NewTilemap(filepath.Join(tdir, `test`), zl, false)
+	wtr, err := NewTilemap(filepath.Join(tdir, `collision`), zl, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -121,9 +121,9 @@ func TestTilemapHighCollision(t *testing.T) {
 }
 
 func TestTilemapReadWrite(t *testing.T) {
-	testMap := make(map[uint32]uint64, 1024)
+	testMap := make(map[uint64]uint64, 1024)
 	zl := 2
-	wtr, err := NewTilemap(filepath.Join(tdir, `test`), zl, false)
+	wtr, err := NewTilemap(filepath.Join(tdir, `readwrite`), zl, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -159,9 +159,9 @@ func TestTilemapReadWrite(t *testing.T) {
 }
 
 func TestTilemapReadWriteReopen(t *testing.T) {
-	testMap := make(map[uint32]uint64, 1024)
+	testMap := make(map[uint64]uint64, 1024)
 	zl := 4
-	wtr, err := NewTilemap(filepath.Join(tdir, `test`), zl, false)
+	wtr, err := NewTilemap(filepath.Join(tdir, `reopen`), zl, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -178,7 +178,7 @@ func TestTilemapReadWriteReopen(t *testing.T) {
 	if err = wtr.Close(); err != nil {
 		t.Fatal(err)
 	}
-	if wtr, err = NewTilemap(filepath.Join(tdir, `test`), zl, false); err != nil {
+	if wtr, err = NewTilemap(filepath.Join(tdir, `reopen`), zl, false); err != nil {
 		t.Fatal(err)
 	}
 	for i := zl / 2; i < zl; i++ {
@@ -195,7 +195,7 @@ func TestTilemapReadWriteReopen(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	rdr, err := NewTilemap(filepath.Join(tdir, `test`), zl, true)
+	rdr, err := NewTilemap(filepath.Join(tdir, `reopen`), zl, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -231,3 +231,148 @@ func randBuff(b []byte) []byte {
 	off := rand.Intn(len(b) - l)
 	return b[off : off+l]
 }
+
+// randTile is randBuff that never returns an empty tile, which Add rejects
+func randTile(b []byte) []byte {
+	for {
+		if r := randBuff(b); len(r) > 0 {
+			return r
+		}
+	}
+}
+
+func TestTilemapReopenDedup(t *testing.T) {
+	zl := 3
+	pth := filepath.Join(tdir, `reopendedup`)
+	blank := randTile(basicBuff)
+	wtr, err := NewTilemap(pth, zl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Add(0, 0, blank); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Add(0, 1, randTile(basicBuff)); err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//reopen and add the same blank tile everywhere else, the file should not grow
+	if wtr, err = NewTilemap(pth, zl, false); err != nil {
+		t.Fatal(err)
+	}
+	dim := 1 << uint(zl)
+	for i := 1; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			if err = wtr.Add(i, j, blank); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi2, err := os.Stat(pth); err != nil {
+		t.Fatal(err)
+	} else if fi2.Size() != fi.Size() {
+		t.Fatalf("file grew after adding duplicate tiles: %d != %d", fi2.Size(), fi.Size())
+	}
+}
+
+func TestTilemapHashCollision(t *testing.T) {
+	zl := 2
+	pth := filepath.Join(tdir, `hashcollision`)
+	//every buffer collides, so dedup must fall back to comparing bytes
+	collide := func([]byte) uint64 { return 1 }
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Hash: collide})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufs := make(map[uint64][]byte)
+	dim := 1 << uint(zl)
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			buff := []byte(fmt.Sprintf("tile %d %d", i, j))
+			if err = wtr.Add(i, j, buff); err != nil {
+				t.Fatal(err)
+			}
+			bufs[tileid(zl, i, j)] = buff
+		}
+	}
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			if buff, err := wtr.GetTile(i, j); err != nil {
+				t.Fatal(err)
+			} else if string(buff) != string(bufs[tileid(zl, i, j)]) {
+				t.Fatalf("collision aliased tile %d %d: %q", i, j, buff)
+			}
+		}
+	}
+
+	//genuine duplicates still share a blob
+	foff := wtr.foff
+	if err = wtr.Add(0, 0, bufs[tileid(zl, 0, 0)]); err != nil {
+		t.Fatal(err)
+	} else if wtr.foff != foff {
+		t.Fatal("duplicate tile was written again")
+	}
+	//so do tiles that lost the race for their hash, before and after a reopen
+	for _, c := range [][2]int{{0, 1}, {2, 3}} {
+		if err = wtr.Add(c[0], c[1], bufs[tileid(zl, 3, 3)]); err != nil {
+			t.Fatal(err)
+		} else if wtr.foff != foff {
+			t.Fatal("colliding duplicate tile was written again")
+		}
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	} else if wtr, err = NewTilemapConfig(pth, Config{Zoom: zl, Hash: collide}); err != nil {
+		t.Fatal(err)
+	}
+	foff = wtr.foff
+	if err = wtr.Add(1, 0, bufs[tileid(zl, 2, 2)]); err != nil {
+		t.Fatal(err)
+	} else if wtr.foff != foff {
+		t.Fatal("colliding duplicate tile was written again after reopen")
+	} else if buff, err := wtr.GetTile(1, 0); err != nil || string(buff) != string(bufs[tileid(zl, 2, 2)]) {
+		t.Fatalf("bad colliding duplicate %q %v", buff, err)
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTilemapNotFound(t *testing.T) {
+	zl := 2
+	wtr, err := NewTilemap(filepath.Join(tdir, `notfound`), zl, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff := randTile(basicBuff)
+	if err = wtr.Add(1, 1, buff); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wtr.GetTile(0, 1); err != ErrTileNotFound {
+		t.Fatalf("Failed to get ErrTileNotFound: %v", err)
+	} else if _, err = wtr.GetTile(4, 0); err != ErrInvalidTileID {
+		t.Fatalf("Failed to catch out of range tile: %v", err)
+	} else if _, err = wtr.TileSize(0, 1); err != ErrTileNotFound {
+		t.Fatalf("Failed to get ErrTileNotFound: %v", err)
+	}
+	if wtr.Has(0, 1) || wtr.Has(-1, 0) || !wtr.Has(1, 1) {
+		t.Fatal("bad Has results")
+	}
+	if sz, err := wtr.TileSize(1, 1); err != nil {
+		t.Fatal(err)
+	} else if sz != int64(len(buff)) {
+		t.Fatalf("bad tile size %d != %d", sz, len(buff))
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
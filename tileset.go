@@ -0,0 +1,594 @@
+package tilemap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TilesExtension = `.tiles`
+
+	containerMagic     = "GWTILSET"
+	containerVersion   = 1
+	containerEntrySize = 24
+	containerAlign     = 64 * 1024 //sections are mapped, so keep them aligned for any page size
+)
+
+var (
+	ErrZoomLoaded       = errors.New("tilemap zoom level is already loaded")
+	ErrInvalidContainer = errors.New("invalid tileset container, file may be corrupt")
+	ErrDirtyTilemap     = errors.New("tilemap was not closed cleanly or is open for writing")
+)
+
+// Tileset manages a pyramid of tilemaps, one per zoom level.
+// A tileset is either a directory tree of tiles files or a read only container built with PackTileset.
+type Tileset struct {
+	sync.RWMutex
+	pth  string
+	cfg  Config
+	tms  []*Tilemap //indexed by zoom
+	cont storage    //non-nil when backed by a container
+	fsys fs.FS      //non-nil when opened out of a file system
+}
+
+// TilesetStats are the combined stats of all tilemaps in a tileset
+type TilesetStats struct {
+	Zooms       []int
+	Tiles       int64 //populated tiles
+	UniqueBlobs int64 //unique blobs summed across zoom levels
+	Bytes       int64 //total size of all tilemaps
+	DeadBytes   int64
+	Levels      []Stats //per zoom stats, aligned with Zooms
+}
+
+// OpenTileset opens a tileset directory or container
+func OpenTileset(pth string, ro bool) (ts *Tileset, err error) {
+	return OpenTilesetConfig(pth, Config{ReadOnly: ro})
+}
+
+// OpenTilesetConfig opens a tileset, the config Zoom is ignored and the remaining
+// fields are used when opening existing zoom files or creating new ones.
+func OpenTilesetConfig(pth string, c Config) (ts *Tileset, err error) {
+	var fi os.FileInfo
+	if pth == `` {
+		err = errors.New("invalid path")
+		return
+	}
+	c.Zoom = AnyZoom
+	ts = &Tileset{
+		pth: pth,
+		cfg: c,
+		tms: make([]*Tilemap, MaxZoom+1),
+	}
+	if fi, err = os.Stat(pth); err != nil {
+		if !os.IsNotExist(err) || c.ReadOnly {
+			ts = nil
+			return
+		} else if err = os.MkdirAll(pth, 0750); err != nil {
+			ts = nil
+			return
+		}
+	} else if fi.Mode().IsRegular() {
+		if err = ts.loadContainer(); err != nil {
+			ts = nil
+		}
+		return
+	} else if !fi.IsDir() {
+		err = fmt.Errorf("%s is not a directory or tileset container", pth)
+		ts = nil
+		return
+	}
+	if err = ts.loadDir(false); err != nil {
+		ts.Close()
+		ts = nil
+	}
+	return
+}
+
+// OpenTilesetFS opens a read only tileset out of a file system such as an embed.FS.
+// name is either a directory of <zoom>.tiles files or a container built with PackTileset.
+func OpenTilesetFS(fsys fs.FS, name string, c Config) (ts *Tileset, err error) {
+	var fi fs.FileInfo
+	if fi, err = fs.Stat(fsys, name); err != nil {
+		return
+	}
+	c.Zoom = AnyZoom
+	c.ReadOnly = true
+	ts = &Tileset{
+		pth:  name,
+		cfg:  c,
+		tms:  make([]*Tilemap, MaxZoom+1),
+		fsys: fsys,
+	}
+	if fi.IsDir() {
+		if err = ts.loadFSDir(); err != nil {
+			ts.Close()
+		}
+	} else if ts.cont, err = openFSStorage(fsys, name); err == nil {
+		cont := ts.cont
+		err = ts.loadSections(func(off, sz int64) storage {
+			return &readerStorage{rdr: io.NewSectionReader(cont, off, sz), sz: sz}
+		})
+	}
+	if err != nil {
+		ts = nil
+	}
+	return
+}
+
+// loadFSDir opens every tilemap file in a file system directory and its subdirectories
+func (ts *Tileset) loadFSDir() (err error) {
+	err = fs.WalkDir(ts.fsys, ts.pth, func(pth string, ent fs.DirEntry, lerr error) (err error) {
+		if lerr != nil {
+			return lerr
+		} else if !ent.Type().IsRegular() || path.Ext(ent.Name()) != TilesExtension {
+			return //skip anything that isn't a tiles file
+		}
+		var tm *Tilemap
+		if tm, err = OpenTilemapFS(ts.fsys, pth, ts.cfg); err == ErrNoHeader {
+			//legacy files fall back to the <zoom>.tiles name
+			c := ts.cfg
+			if c.Zoom, err = strconv.Atoi(strings.TrimSuffix(ent.Name(), TilesExtension)); err != nil {
+				err = fmt.Errorf("Bad tilemap file name %q: %v", pth, err)
+				return
+			}
+			tm, err = OpenTilemapFS(ts.fsys, pth, c)
+		}
+		if err != nil {
+			err = fmt.Errorf("Failed to open tilemap %q: %v", pth, err)
+			return
+		} else if ts.tms[tm.Zoom()] != nil {
+			tm.Close()
+			err = fmt.Errorf("%v: %d", ErrZoomLoaded, tm.Zoom())
+			return
+		}
+		ts.tms[tm.Zoom()] = tm
+		return
+	})
+	return
+}
+
+// loadDir opens every tilemap file in the directory tree, skipping open files when refreshing.
+// Caller must hold the lock or have exclusive access.
+func (ts *Tileset) loadDir(refresh bool) (err error) {
+	err = filepath.WalkDir(ts.pth, func(pth string, ent fs.DirEntry, lerr error) (err error) {
+		if lerr != nil {
+			return lerr
+		} else if !ent.Type().IsRegular() || filepath.Ext(ent.Name()) != TilesExtension {
+			return //skip anything that isn't a tiles file
+		}
+		var zoom int
+		if zoom, err = tilemapZoom(pth); err != nil {
+			return
+		} else if zoom < 0 || zoom > MaxZoom {
+			err = fmt.Errorf("Bad tilemap file zoom level %q: %d", pth, zoom)
+			return
+		} else if ts.tms[zoom] != nil {
+			if refresh && ts.tms[zoom].pth == pth {
+				return
+			}
+			err = fmt.Errorf("%v: %d %q", ErrZoomLoaded, zoom, pth)
+			return
+		}
+		c := ts.cfg
+		c.Zoom = zoom
+		ts.tms[zoom], err = NewTilemapConfig(pth, c)
+		return
+	})
+	return
+}
+
+// tilemapZoom pulls the zoom out of the file header, legacy files fall back to the <zoom>.tiles name
+func tilemapZoom(pth string) (zoom int, err error) {
+	var h Header
+	if h, err = ReadHeader(pth); err == nil {
+		zoom = h.Zoom
+		return
+	} else if err != ErrNoHeader {
+		err = fmt.Errorf("Bad tilemap file header %q: %v", pth, err)
+		return
+	}
+	fn := strings.TrimSuffix(filepath.Base(pth), TilesExtension)
+	if zoom, err = strconv.Atoi(fn); err != nil {
+		err = fmt.Errorf("Bad tilemap file name %q: %v", pth, err)
+	}
+	return
+}
+
+// zoomPath returns the path of the tilemap file for a zoom level
+func (ts *Tileset) zoomPath(zoom int) string {
+	return filepath.Join(ts.pth, fmt.Sprintf("%d%s", zoom, TilesExtension))
+}
+
+// Tilemap returns the tilemap for a zoom level, nil is returned if the zoom is not present
+func (ts *Tileset) Tilemap(zoom int) *Tilemap {
+	ts.RLock()
+	defer ts.RUnlock()
+	if zoom < 0 || zoom >= len(ts.tms) {
+		return nil
+	}
+	return ts.tms[zoom]
+}
+
+// Zooms returns the zoom levels present in the tileset in ascending order
+func (ts *Tileset) Zooms() (r []int) {
+	ts.RLock()
+	defer ts.RUnlock()
+	for z, tm := range ts.tms {
+		if tm != nil {
+			r = append(r, z)
+		}
+	}
+	return
+}
+
+// GetTile returns a tile, ErrTileNotFound is returned if the zoom level is not present
+func (ts *Tileset) GetTile(zoom, x, y int) (buff []byte, err error) {
+	if tm := ts.Tilemap(zoom); tm == nil {
+		err = ErrTileNotFound
+	} else {
+		buff, err = tm.GetTile(x, y)
+	}
+	return
+}
+
+// GetTileView returns a zero copy view of a tile, see Tilemap.GetTileView for the lifetime rules
+func (ts *Tileset) GetTileView(zoom, x, y int) (view []byte, err error) {
+	if tm := ts.Tilemap(zoom); tm == nil {
+		err = ErrTileNotFound
+	} else {
+		view, err = tm.GetTileView(x, y)
+	}
+	return
+}
+
+// WriteTileTo writes a tile to wtr, streaming it out of the file mapping when possible
+func (ts *Tileset) WriteTileTo(zoom, x, y int, wtr io.Writer) (n int64, err error) {
+	if tm := ts.Tilemap(zoom); tm == nil {
+		err = ErrTileNotFound
+	} else {
+		n, err = tm.WriteTileTo(x, y, wtr)
+	}
+	return
+}
+
+// WriteRawTileTo writes a tile to wtr as stored, see Tilemap.WriteRawTileTo
+func (ts *Tileset) WriteRawTileTo(zoom, x, y int, wtr io.Writer) (n int64, err error) {
+	if tm := ts.Tilemap(zoom); tm == nil {
+		err = ErrTileNotFound
+	} else {
+		n, err = tm.WriteRawTileTo(x, y, wtr)
+	}
+	return
+}
+
+// Compression returns the compression used by a zoom level, CompressNone if the level is not present
+func (ts *Tileset) Compression(zoom int) (c Compression) {
+	if tm := ts.Tilemap(zoom); tm != nil {
+		c = tm.Compression()
+	}
+	return
+}
+
+// Add writes a tile, creating the zoom level file if needed
+func (ts *Tileset) Add(zoom, x, y int, buff []byte) (err error) {
+	var tm *Tilemap
+	if tm, err = ts.writer(zoom); err == nil {
+		err = tm.Add(x, y, buff)
+	}
+	return
+}
+
+// AddBatch writes a set of tiles to a single zoom level, see Tilemap.AddBatch
+func (ts *Tileset) AddBatch(zoom int, tiles []Tile) (err error) {
+	var tm *Tilemap
+	if tm, err = ts.writer(zoom); err == nil {
+		err = tm.AddBatch(tiles)
+	}
+	return
+}
+
+// Flush writes out and publishes buffered tiles on every zoom level
+func (ts *Tileset) Flush() (err error) {
+	ts.RLock()
+	defer ts.RUnlock()
+	for z, tm := range ts.tms {
+		if tm == nil {
+			continue
+		}
+		if lerr := tm.Flush(); lerr != nil {
+			err = fmt.Errorf("Failed to flush tilemap %d: %v", z, lerr)
+		}
+	}
+	return
+}
+
+// writer gets the tilemap for a zoom level, lazily creating it
+func (ts *Tileset) writer(zoom int) (tm *Tilemap, err error) {
+	return ts.writerWith(zoom, nil)
+}
+
+// writerWith is writer with a hook to adjust the config of a newly created zoom level
+func (ts *Tileset) writerWith(zoom int, adjust func(*Config)) (tm *Tilemap, err error) {
+	if zoom < 0 || zoom > MaxZoom {
+		err = ErrInvalidDimension
+		return
+	} else if ts.cfg.ReadOnly || ts.cont != nil {
+		err = ErrReadOnly
+		return
+	}
+	if tm = ts.Tilemap(zoom); tm != nil {
+		return
+	}
+	ts.Lock()
+	defer ts.Unlock()
+	if tm = ts.tms[zoom]; tm == nil {
+		c := ts.cfg
+		c.Zoom = zoom
+		if adjust != nil {
+			adjust(&c)
+		}
+		if tm, err = NewTilemapConfig(ts.zoomPath(zoom), c); err == nil {
+			ts.tms[zoom] = tm
+		}
+	}
+	return
+}
+
+// Stats returns the combined stats of every zoom level
+func (ts *Tileset) Stats() (st TilesetStats, err error) {
+	ts.RLock()
+	defer ts.RUnlock()
+	for z, tm := range ts.tms {
+		if tm == nil {
+			continue
+		}
+		var zst Stats
+		if zst, err = tm.Stats(); err != nil {
+			return
+		}
+		st.Zooms = append(st.Zooms, z)
+		st.Levels = append(st.Levels, zst)
+		st.Tiles += zst.Tiles
+		st.UniqueBlobs += zst.UniqueBlobs
+		st.Bytes += zst.FileBytes
+		st.DeadBytes += zst.DeadBytes
+	}
+	return
+}
+
+// Refresh picks up tiles and new zoom levels written to a tileset directory by another process
+func (ts *Tileset) Refresh() (err error) {
+	ts.Lock()
+	defer ts.Unlock()
+	for z, tm := range ts.tms {
+		if tm == nil {
+			continue
+		}
+		if lerr := tm.Refresh(); lerr != nil {
+			err = fmt.Errorf("Failed to refresh tilemap %d: %v", z, lerr)
+		}
+	}
+	if ts.cont == nil && ts.fsys == nil && ts.cfg.ReadOnly {
+		if lerr := ts.loadDir(true); lerr != nil {
+			err = lerr
+		}
+	}
+	return
+}
+
+// Close closes every tilemap in the tileset
+func (ts *Tileset) Close() (err error) {
+	ts.Lock()
+	defer ts.Unlock()
+	for z, tm := range ts.tms {
+		if tm == nil {
+			continue
+		}
+		if lerr := tm.Close(); lerr != nil {
+			err = fmt.Errorf("Failed to close tilemap %d: %v", z, lerr)
+		}
+		ts.tms[z] = nil
+	}
+	if ts.cont != nil {
+		if lerr := ts.cont.close(); lerr != nil {
+			err = lerr
+		}
+		ts.cont = nil
+	}
+	return
+}
+
+// container layout, all values are little endian
+// [0:8]   magic
+// [8:10]  version
+// [10:12] entry count
+// [16:]   entries of zoom (1 byte, 7 reserved), offset (8 bytes), size (8 bytes)
+// each tilemap is copied verbatim into the container aligned to containerAlign
+
+type containerEntry struct {
+	zoom   int
+	offset int64
+	size   int64
+}
+
+func (ts *Tileset) loadContainer() (err error) {
+	if !ts.cfg.ReadOnly {
+		err = fmt.Errorf("%v: tileset containers must be opened read only", ErrReadOnly)
+		return
+	}
+	var fio *os.File
+	if fio, err = os.Open(ts.pth); err != nil {
+		return
+	}
+	ts.cont = &fileStorage{fio: fio, ro: true}
+	err = ts.loadSections(func(off, sz int64) storage {
+		return sectionStorage(fio, off, sz)
+	})
+	return
+}
+
+// loadSections opens every tilemap in the container, section returns the storage for a tilemap
+func (ts *Tileset) loadSections(section func(off, sz int64) storage) (err error) {
+	var size int64
+	var ents []containerEntry
+	if size, err = ts.cont.size(); err != nil {
+		ts.Close()
+		return
+	} else if ents, err = readContainerEntries(ts.cont, size); err != nil {
+		ts.Close()
+		return
+	}
+	for _, ent := range ents {
+		if ts.tms[ent.zoom] != nil {
+			err = fmt.Errorf("%v: %d", ErrZoomLoaded, ent.zoom)
+			break
+		}
+		c := ts.cfg
+		c.Zoom = ent.zoom
+		if ts.tms[ent.zoom], err = newTilemap(section(ent.offset, ent.size), c); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		ts.Close()
+	}
+	return
+}
+
+func readContainerEntries(fin io.ReaderAt, fsize int64) (ents []containerEntry, err error) {
+	buff := make([]byte, containerAlign)
+	if fsize < int64(len(buff)) {
+		err = ErrInvalidContainer
+		return
+	} else if _, err = fin.ReadAt(buff, 0); err != nil {
+		return
+	} else if string(buff[:len(containerMagic)]) != containerMagic {
+		err = ErrInvalidContainer
+		return
+	} else if binary.LittleEndian.Uint16(buff[8:]) != containerVersion {
+		err = ErrUnsupportedVersion
+		return
+	}
+	cnt := int(binary.LittleEndian.Uint16(buff[10:]))
+	if cnt > MaxZoom+1 {
+		err = ErrInvalidContainer
+		return
+	}
+	for i := 0; i < cnt; i++ {
+		b := buff[16+i*containerEntrySize:]
+		ent := containerEntry{
+			zoom:   int(b[0]),
+			offset: int64(binary.LittleEndian.Uint64(b[8:])),
+			size:   int64(binary.LittleEndian.Uint64(b[16:])),
+		}
+		if ent.zoom > MaxZoom || ent.offset%containerAlign != 0 || ent.offset < containerAlign ||
+			ent.size <= 0 || ent.offset+ent.size > fsize {
+			err = ErrInvalidContainer
+			return
+		}
+		ents = append(ents, ent)
+	}
+	return
+}
+
+// PackTileset copies every tilemap in a tileset directory into a single read only container.
+// Tilemaps that are dirty or open for writing are refused.
+func PackTileset(dir, pth string) (err error) {
+	var ts *Tileset
+	if ts, err = OpenTileset(dir, true); err != nil {
+		return
+	}
+	defer ts.Close()
+	if ts.cont != nil {
+		err = errors.New("tileset is already a container")
+		return
+	}
+
+	var fout *os.File
+	if fout, err = os.Create(pth); err != nil {
+		return
+	}
+	var ents []containerEntry
+	off := int64(containerAlign)
+	for _, z := range ts.Zooms() {
+		var n int64
+		zp := ts.zoomPathOf(z)
+		if n, err = copyFileAt(fout, zp, off); err != nil {
+			break
+		} else if err = checkClean(fout, off, n); err != nil {
+			err = fmt.Errorf("%v: %s", err, zp)
+			break
+		}
+		ents = append(ents, containerEntry{zoom: z, offset: off, size: n})
+		off += (n + containerAlign - 1) / containerAlign * containerAlign
+	}
+	if err == nil {
+		buff := make([]byte, containerAlign)
+		copy(buff, containerMagic)
+		binary.LittleEndian.PutUint16(buff[8:], containerVersion)
+		binary.LittleEndian.PutUint16(buff[10:], uint16(len(ents)))
+		for i, ent := range ents {
+			b := buff[16+i*containerEntrySize:]
+			b[0] = uint8(ent.zoom)
+			binary.LittleEndian.PutUint64(b[8:], uint64(ent.offset))
+			binary.LittleEndian.PutUint64(b[16:], uint64(ent.size))
+		}
+		if _, err = fout.WriteAt(buff, 0); err == nil {
+			err = fout.Truncate(off)
+		}
+	}
+	if err != nil {
+		fout.Close()
+		os.Remove(pth)
+	} else if err = fout.Close(); err != nil {
+		os.Remove(pth)
+	}
+	return
+}
+
+// zoomPathOf returns the path of an opened tilemap, files do not have to follow the naming convention
+func (ts *Tileset) zoomPathOf(zoom int) string {
+	if tm := ts.Tilemap(zoom); tm != nil && tm.pth != `` {
+		return tm.pth
+	}
+	return ts.zoomPath(zoom)
+}
+
+// checkClean returns ErrDirtyTilemap if the tilemap copied to off was marked dirty when its header was copied
+func checkClean(fin io.ReaderAt, off, n int64) (err error) {
+	if n < headerSize {
+		return //too small to hold a header, legacy files have no dirty flag
+	}
+	var hdr Header
+	buff := make([]byte, headerSize)
+	if _, err = fin.ReadAt(buff, off); err != nil {
+		return
+	} else if !hasMagic(buff) {
+		return
+	} else if err = hdr.Decode(buff); err == nil && hdr.Dirty {
+		err = ErrDirtyTilemap
+	}
+	return
+}
+
+func copyFileAt(fout *os.File, src string, off int64) (n int64, err error) {
+	var fin *os.File
+	if fin, err = os.Open(src); err != nil {
+		return
+	}
+	n, err = io.Copy(io.NewOffsetWriter(fout, off), fin)
+	if lerr := fin.Close(); lerr != nil && err == nil {
+		err = lerr
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package tilemap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTileset(t *testing.T) {
+	dir := filepath.Join(tdir, `tileset`)
+	ts, err := OpenTileset(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zooms := []int{0, 2, 3}
+	for _, z := range zooms {
+		dim := 1 << uint(z)
+		for i := 0; i < dim; i++ {
+			for j := 0; j < dim; j++ {
+				if err = ts.Add(z, i, j, []byte(fmt.Sprintf("%d/%d/%d", z, i, j))); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if err = ts.Add(MaxZoom+1, 0, 0, basicBuff[:10]); err != ErrInvalidDimension {
+		t.Fatalf("Failed to catch bad zoom: %v", err)
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ts, err = OpenTileset(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	checkTileset(t, ts, zooms)
+	if st, err := ts.Stats(); err != nil {
+		t.Fatal(err)
+	} else if st.Tiles != 1+16+64 || len(st.Zooms) != len(zooms) || st.DeadBytes != 0 {
+		t.Fatalf("bad stats: %+v", st)
+	}
+	if err = ts.Add(0, 0, 0, basicBuff[:10]); err != ErrReadOnly {
+		t.Fatalf("Failed to catch write on read only tileset: %v", err)
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a tilemap open for writing is marked dirty and can't be packed
+	cpth := filepath.Join(tdir, `tileset.container`)
+	wtr, err := OpenTilemap(filepath.Join(dir, `2`+TilesExtension), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = PackTileset(dir, cpth); err == nil || !strings.HasPrefix(err.Error(), ErrDirtyTilemap.Error()) {
+		t.Fatalf("Failed to catch dirty tilemap: %v", err)
+	} else if _, err = os.Stat(cpth); !os.IsNotExist(err) {
+		t.Fatalf("failed pack left a container behind: %v", err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//pack it up and read from the container
+	if err = PackTileset(dir, cpth); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenTileset(cpth, false); err == nil {
+		t.Fatal("Failed to catch writable container")
+	}
+	if ts, err = OpenTileset(cpth, true); err != nil {
+		t.Fatal(err)
+	}
+	checkTileset(t, ts, zooms)
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTileset(t *testing.T, ts *Tileset, zooms []int) {
+	if zs := ts.Zooms(); fmt.Sprint(zs) != fmt.Sprint(zooms) {
+		t.Fatalf("bad zooms %v != %v", zs, zooms)
+	}
+	for _, z := range zooms {
+		dim := 1 << uint(z)
+		for i := 0; i < dim; i++ {
+			for j := 0; j < dim; j++ {
+				if buff, err := ts.GetTile(z, i, j); err != nil {
+					t.Fatal(err)
+				} else if string(buff) != fmt.Sprintf("%d/%d/%d", z, i, j) {
+					t.Fatalf("bad tile %d/%d/%d: %q", z, i, j, buff)
+				}
+			}
+		}
+	}
+	if _, err := ts.GetTile(1, 0, 0); err != ErrTileNotFound {
+		t.Fatalf("Failed to get ErrTileNotFound for missing zoom: %v", err)
+	}
+}
+
+func TestTilesetNested(t *testing.T) {
+	dir := filepath.Join(tdir, `tilesetnested`)
+	for z, sub := range []string{``, `a`, `a/b`} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			t.Fatal(err)
+		}
+		tm, err := NewTilemap(filepath.Join(dir, sub, fmt.Sprintf("%d%s", z, TilesExtension)), z, false)
+		if err != nil {
+			t.Fatal(err)
+		} else if err = tm.Add(0, 0, []byte(fmt.Sprintf("%d/0/0", z))); err != nil {
+			t.Fatal(err)
+		} else if err = tm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts, err := OpenTileset(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if zooms := ts.Zooms(); len(zooms) != 3 {
+		t.Fatalf("nested zooms were not loaded %v", zooms)
+	} else if buff, err := ts.GetTile(2, 0, 0); err != nil || string(buff) != `2/0/0` {
+		t.Fatalf("bad nested tile %q %v", buff, err)
+	} else if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err = OpenTilesetFS(os.DirFS(tdir), `tilesetnested`, Config{}); err != nil {
+		t.Fatal(err)
+	} else if zooms := ts.Zooms(); len(zooms) != 3 {
+		t.Fatalf("nested zooms were not loaded from the file system %v", zooms)
+	} else if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a zoom may only appear once in the tree
+	tm, err := NewTilemap(filepath.Join(dir, `a`, `dup`+TilesExtension), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = tm.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err = OpenTileset(dir, true); err == nil {
+		t.Fatal("Failed to catch a duplicate nested zoom")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +13,10 @@ import (
 	"github.com/gravwell/tilemap"
 )
 
+const (
+	writeBuffer = 8 * 1024 * 1024 //coalesce rendered tiles into large writes
+)
+
 var (
 	fTileFile  = flag.String("map-file", ``, "Path to mapnic render file XML")
 	fFontsPath = flag.String("fonts-dir", `/usr/share/fonts/truetype/dejavu,/usr/share/fonts/truetype/noto,/usr/share/fonts/truetype/unifont`,
@@ -21,14 +24,25 @@ var (
 	fThreads = flag.Int("threads", 4, "Number of threads to use")
 	fZooms   = flag.String("zooms", "0-15", "Zoom levels to generate")
 	fTileDir = flag.String("tile-dir", `/tmp/tiles`, "Path to output for tilemaps")
+	fCurve   = flag.String("curve", `rowmajor`, "Tile id curve for new tilemaps: rowmajor, morton, or hilbert.  Hilbert keeps neighbouring tiles close on disk")
+	fFormat  = flag.String("format", `png`, "Tile format to render: png, jpeg, or webp")
 )
 
 func main() {
 	var err error
 	var zooms []uint64
+	var curve tilemap.Curve
+	var format tilemap.Format
+	var renderFmt string
 	flag.Parse()
 	if zooms, err = parseZooms(*fZooms); err != nil {
 		log.Fatal("Failed to parse zooms", err)
+	} else if curve, err = tilemap.ParseCurve(*fCurve); err != nil {
+		log.Fatal("Failed to parse curve", err)
+	} else if format, err = tilemap.ParseFormat(*fFormat); err != nil {
+		log.Fatal("Failed to parse format", err)
+	} else if renderFmt, err = renderFormat(format); err != nil {
+		log.Fatal(err)
 	}
 
 	if *fFontsPath != `` {
@@ -39,14 +53,9 @@ func main() {
 	}
 	log.Println("Fonts registered")
 
-	tms := make(map[uint64]*tilemap.Tilemap, len(zooms))
-	for _, z := range zooms {
-		fname := filepath.Join(*fTileDir, fmt.Sprintf("%d.tiles", z))
-		wtr, err := tilemap.NewTilemap(fname, int(z), false)
-		if err != nil {
-			log.Fatal(err)
-		}
-		tms[z] = wtr
+	ts, err := tilemap.OpenTilesetConfig(*fTileDir, tilemap.Config{WriteBuffer: writeBuffer, Curve: curve, Format: format})
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	reqChan := make(chan renderreq, *fThreads+1)
@@ -55,7 +64,7 @@ func main() {
 	wwg := sync.WaitGroup{}
 	wwg.Add(1)
 	go func(wg *sync.WaitGroup) {
-		if err = writer(tms, resChan); err != nil {
+		if err = writer(ts, resChan); err != nil {
 			log.Fatal("Worker error", err)
 		}
 		wg.Done()
@@ -66,7 +75,7 @@ func main() {
 	twg.Add(*fThreads)
 	for i := 0; i < *fThreads; i++ {
 		time.Sleep(time.Second)
-		w, err := NewWorker(*fTileFile, i)
+		w, err := NewWorker(*fTileFile, renderFmt, i)
 		if err != nil {
 			log.Fatal("Failed to make worker", i)
 		}
@@ -74,16 +83,16 @@ func main() {
 		log.Printf("Fired thread %d/%d\n", i+1, *fThreads)
 	}
 
+	//feed tiles in curve order so rendered tiles land in the file near their neighbours
 	log.Println("Feeding zooms")
 	for _, z := range zooms {
-		dim := 1 << z
-		for i := 0; i < dim; i++ {
-			for j := 0; j < dim; j++ {
-				reqChan <- renderreq{
-					zoom: z,
-					x:    uint64(i),
-					y:    uint64(j),
-				}
+		count := uint64(1) << (2 * z)
+		for id := uint64(0); id < count; id++ {
+			x, y := curve.TileXY(int(z), id)
+			reqChan <- renderreq{
+				zoom: z,
+				x:    uint64(x),
+				y:    uint64(y),
 			}
 		}
 	}
@@ -94,11 +103,18 @@ func main() {
 	close(resChan)
 	wwg.Wait()
 
-	for _, v := range tms {
-		if err = v.Close(); err != nil {
-			log.Fatal(err)
+	if st, err := ts.Stats(); err != nil {
+		log.Println("Failed to get tileset stats", err)
+	} else {
+		for i, z := range st.Zooms {
+			zs := st.Levels[i]
+			log.Printf("Zoom %d: %d tiles, %d unique blobs (%.2fx dedup), %d byte average, %d dead bytes\n",
+				z, zs.Tiles, zs.UniqueBlobs, zs.DedupRatio, zs.AvgBlobSize, zs.DeadBytes)
 		}
 	}
+	if err = ts.Close(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 type renderreq struct {
@@ -112,7 +128,7 @@ type rendered struct {
 	err  error
 }
 
-func writer(tms map[uint64]*tilemap.Tilemap, in <-chan rendered) error {
+func writer(tiles *tilemap.Tileset, in <-chan rendered) error {
 	var cnt uint64
 	var total uint64
 	var lastz uint
@@ -136,11 +152,7 @@ consume:
 			if v.err != nil {
 				return v.err
 			}
-			tm, ok := tms[uint64(v.zoom)]
-			if !ok {
-				return fmt.Errorf("got render result for invalid writer %d", v.zoom)
-			}
-			if err := tm.Add(int(v.x), int(v.y), v.buff); err != nil {
+			if err := tiles.Add(int(v.zoom), int(v.x), int(v.y), v.buff); err != nil {
 				return err
 			}
 			if v.zoom != lastz {
@@ -178,6 +190,19 @@ consume:
 	return nil
 }
 
+// renderFormat returns the mapnik output format for a tile format, mapnik only renders images
+func renderFormat(f tilemap.Format) (string, error) {
+	switch f {
+	case tilemap.FormatPNG:
+		return `png256`, nil
+	case tilemap.FormatJPEG:
+		return `jpeg85`, nil
+	case tilemap.FormatWebP:
+		return `webp`, nil
+	}
+	return ``, fmt.Errorf("mapnik cannot render %s tiles", f)
+}
+
 func parseZooms(s string) (r []uint64, err error) {
 	var minS, maxS string
 	var min, max uint64
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/gravwell/tilemap"
+)
+
+var (
+	fMode      = flag.String("mode", `first`, "How overlapping tiles are combined: first or overlay")
+	fCurve     = flag.String("curve", `rowmajor`, "Tile id curve: rowmajor, morton, or hilbert.  Hilbert keeps neighbouring tiles close on disk")
+	fIndex     = flag.String("index", `dense`, "Tile index: dense or sparse, zooms above 16 are always sparse")
+	fCompress  = flag.String("compress", `none`, "Tile compression: none, gzip, or zstd")
+	fChecksums = flag.Bool("checksums", false, "Store a CRC32C after each tile")
+)
+
+func main() {
+	var err error
+	var c tilemap.Config
+	var mode tilemap.MergeMode
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		log.Fatalf("Invalid command, need %s [options] <output> <input tilemap>... in order of precedence\n", os.Args[0])
+	}
+	if mode, err = tilemap.ParseMergeMode(*fMode); err != nil {
+		log.Fatalf("bad mode %q: %v\n", *fMode, err)
+	} else if c.Curve, err = tilemap.ParseCurve(*fCurve); err != nil {
+		log.Fatalf("bad curve %q: %v\n", *fCurve, err)
+	} else if c.Index, err = tilemap.ParseIndexType(*fIndex); err != nil {
+		log.Fatalf("bad index %q: %v\n", *fIndex, err)
+	} else if c.Compression, err = tilemap.ParseCompression(*fCompress); err != nil {
+		log.Fatalf("bad compression %q: %v\n", *fCompress, err)
+	}
+	c.Checksums = *fChecksums
+
+	dst := args[0]
+	var srcs []*tilemap.Tilemap
+	for _, pth := range args[1:] {
+		var tm *tilemap.Tilemap
+		if tm, err = tilemap.NewTilemapConfig(pth, tilemap.Config{Zoom: tilemap.AnyZoom, ReadOnly: true}); err != nil {
+			log.Fatalf("Failed to open %s: %v\n", pth, err)
+		}
+		srcs = append(srcs, tm)
+	}
+	err = tilemap.Merge(srcs, dst, c, mode)
+	for _, tm := range srcs {
+		tm.Close()
+	}
+	if err != nil {
+		log.Fatalf("Failed to merge into %s: %v\n", dst, err)
+	}
+	log.Printf("Merged %d tilemaps into %s\n", len(srcs), dst)
+}
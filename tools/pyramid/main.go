@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"image/color"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/gravwell/tilemap"
+)
+
+var (
+	fZoom    = flag.Int("zoom", -1, "Zoom level to build from, defaults to the highest zoom in the tileset")
+	fMinZoom = flag.Int("min-zoom", 0, "Lowest zoom level to generate")
+	fWorkers = flag.Int("workers", runtime.NumCPU(), "Number of tiles to composite in parallel")
+	fBack    = flag.String("background", ``, "Fill for missing child tiles as RRGGBB or RRGGBBAA hex, defaults to transparent for PNG and white for JPEG")
+)
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 1 {
+		log.Fatalf("Invalid command, need %s [options] <tiles dir>\n", os.Args[0])
+	}
+	ts, err := tilemap.OpenTileset(args[0], false)
+	if err != nil {
+		log.Fatalf("Failed to open tileset %s: %v\n", args[0], err)
+	}
+	zoom := *fZoom
+	if zoom < 0 {
+		if zooms := ts.Zooms(); len(zooms) > 0 {
+			zoom = zooms[len(zooms)-1]
+		} else {
+			log.Fatalf("%s has no zoom levels\n", args[0])
+		}
+	}
+	c := tilemap.PyramidConfig{
+		Workers: *fWorkers,
+		MinZoom: *fMinZoom,
+	}
+	if *fBack != `` {
+		if c.Background, err = parseColor(*fBack); err != nil {
+			log.Fatalf("bad background %q: %v\n", *fBack, err)
+		}
+	}
+	if err = tilemap.BuildPyramid(ts, zoom, c); err != nil {
+		ts.Close()
+		log.Fatalf("Failed to build pyramid from zoom %d: %v\n", zoom, err)
+	}
+
+	if st, err := ts.Stats(); err != nil {
+		log.Println("Failed to get tileset stats", err)
+	} else {
+		for i, z := range st.Zooms {
+			zs := st.Levels[i]
+			log.Printf("Zoom %d: %d tiles, %d unique blobs (%.2fx dedup), %d byte average\n",
+				z, zs.Tiles, zs.UniqueBlobs, zs.DedupRatio, zs.AvgBlobSize)
+		}
+	}
+	if err = ts.Close(); err != nil {
+		log.Fatalf("Failed to close tileset: %v\n", err)
+	}
+}
+
+// parseColor parses RRGGBB or RRGGBBAA hex into a premultiplied color
+func parseColor(v string) (c color.RGBA, err error) {
+	var b []byte
+	if b, err = hex.DecodeString(v); err != nil {
+		return
+	} else if len(b) != 3 && len(b) != 4 {
+		err = errors.New("need RRGGBB or RRGGBBAA")
+		return
+	}
+	nc := color.NRGBA{R: b[0], G: b[1], B: b[2], A: 255}
+	if len(b) == 4 {
+		nc.A = b[3]
+	}
+	c = color.RGBAModel.Convert(nc).(color.RGBA)
+	return
+}
@@ -9,7 +9,7 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
 
@@ -18,24 +18,25 @@ import (
 )
 
 const (
-	pngExt            = `.png`
 	maxFileSize int64 = 1024 * 512 //512kb is the maximum tile size we allow here
+	writeBuffer       = 8 * 1024 * 1024
 )
 
 var (
-	maxZoom                 = flag.Int("max-zoom", 15, "Maximum level of zoom, must be < 20")
-	ErrInvalidFileExtension = errors.New("Invalid png file extension")
+	maxZoom                 = flag.Int("max-zoom", 15, fmt.Sprintf("Maximum level of zoom, must be <= %d", tilemap.MaxZoom))
+	tms                     = flag.Bool("tms", false, "Tile paths use TMS coordinates with the y axis flipped")
+	format                  = flag.String("format", ``, "Tile format: png, jpeg, webp, mvt, or json.  Defaults to the extension of the first tile")
+	ErrInvalidFileExtension = errors.New("Invalid tile file extension")
 
 	baseDir string
 )
 
 func main() {
 	flag.Parse()
-	if *maxZoom < 0 || *maxZoom >= 20 {
+	if *maxZoom < 0 || *maxZoom > tilemap.MaxZoom {
 		log.Fatal("Invalid zoom level")
 	}
 	args := flag.Args()
-	tmm := make([]*tilemap.Tilemap, *maxZoom+1)
 	if len(args) != 2 {
 		log.Fatalf("Invalid command, need %s <input tar> <output dir>\n", os.Args[0])
 	}
@@ -46,31 +47,39 @@ func main() {
 		log.Fatalf("%s is not a directory\n", baseDir)
 	}
 
+	var f tilemap.Format
+	if *format != `` {
+		var err error
+		if f, err = tilemap.ParseFormat(*format); err != nil {
+			log.Fatalf("Invalid format %q\n", *format)
+		}
+	}
 	rdr, err := utils.OpenBufferedFileReader(args[0], 1024*1024)
 	if err != nil {
 		log.Fatalf("Failed to open %s: %v\n", args[0], err)
 	}
-	if err := tarRunner(rdr, tmm); err != nil {
+	ts, err := tarRunner(rdr, f)
+	if err != nil {
 		log.Fatalf("Failed to run: %v\n", err)
 	}
 
 	if err := rdr.Close(); err != nil {
 		log.Fatalf("Failed to close reader: %v\n", err)
 	}
-	for _, v := range tmm {
-		if v == nil {
-			continue
-		}
-		if err := v.Close(); err != nil {
-			log.Fatalf("Failed to close map: %v\n", err)
+	if ts != nil {
+		if err := ts.Close(); err != nil {
+			log.Fatalf("Failed to close tileset: %v\n", err)
 		}
 	}
 }
 
-func tarRunner(r io.Reader, tmm []*tilemap.Tilemap) (err error) {
+// tarRunner imports every tile in the tar.  The tileset is opened with the first tile so the
+// format can come from its extension, every tile has to share that format.
+func tarRunner(r io.Reader, f tilemap.Format) (ts *tilemap.Tileset, err error) {
 	tr := tar.NewReader(r)
 	var hdr *tar.Header
 	var zoom, x, y int
+	var tf tilemap.Format
 	var added uint
 	bb := bytes.NewBuffer(make([]byte, maxFileSize))
 	for {
@@ -83,21 +92,33 @@ func tarRunner(r io.Reader, tmm []*tilemap.Tilemap) (err error) {
 		if hdr.Typeflag != tar.TypeReg {
 			continue
 		}
-		if zoom, x, y, err = processFilename(hdr.Name); err != nil {
+		if zoom, x, y, tf, err = processFilename(hdr.Name); err != nil {
 			return
-		} else if zoom < 0 || zoom >= len(tmm) {
+		} else if zoom < 0 || zoom > *maxZoom {
 			continue
 		}
-
-		if tmm[zoom] == nil {
-			fname := filepath.Join(baseDir, fmt.Sprintf("%d.tiles", zoom))
-			if tmm[zoom], err = tilemap.NewTilemap(fname, zoom, false); err != nil {
+		if f == tilemap.FormatUnknown {
+			f = tf
+		} else if tf != f {
+			err = fmt.Errorf("%s is not a %s tile", hdr.Name, f)
+			return
+		}
+		if ts == nil {
+			if ts, err = tilemap.OpenTilesetConfig(baseDir, tilemap.Config{WriteBuffer: writeBuffer, Format: f}); err != nil {
+				err = fmt.Errorf("Failed to open tileset %s: %v", baseDir, err)
 				return
 			}
 		}
+
 		bb.Reset()
 		io.Copy(bb, tr)
-		if err = tmm[zoom].Add(x, y, bb.Bytes()); err != nil {
+		if *tms {
+			err = ts.AddTMS(tilemap.TMSCoord{Zoom: zoom, X: x, Y: y}, bb.Bytes())
+		} else {
+			err = ts.Add(zoom, x, y, bb.Bytes())
+		}
+		if err != nil {
+			err = fmt.Errorf("Failed to add %s: %v", hdr.Name, err)
 			return
 		}
 		added++
@@ -106,17 +127,19 @@ func tarRunner(r io.Reader, tmm []*tilemap.Tilemap) (err error) {
 	return
 }
 
-func processFilename(pth string) (zoom, x, y int, err error) {
+func processFilename(pth string) (zoom, x, y int, f tilemap.Format, err error) {
 	var bits []string
 	//get the zoom level, x, and y value
 	if bits = strings.SplitN(pth, `/`, 3); len(bits) != 3 {
 		err = fmt.Errorf("Invalid filepath: %v %v", pth, bits)
 		return
-	} else if !strings.HasSuffix(bits[2], pngExt) {
-		err = fmt.Errorf("invalid base file name: %v", bits[2])
+	}
+	ext := path.Ext(bits[2])
+	if f, err = tilemap.ParseFormat(ext); err != nil {
+		err = fmt.Errorf("%v: %v", ErrInvalidFileExtension, bits[2])
 		return
 	}
-	bits[2] = strings.TrimSuffix(bits[2], pngExt)
+	bits[2] = strings.TrimSuffix(bits[2], ext)
 	if zoom, err = strconv.Atoi(bits[0]); err != nil {
 		return
 	}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gravwell/tilemap"
+)
+
+var (
+	fCurve     = flag.String("curve", `rowmajor`, "Tile id curve: rowmajor, morton, or hilbert.  Hilbert keeps neighbouring tiles close on disk")
+	fIndex     = flag.String("index", `dense`, "Tile index: dense or sparse, zooms above 16 are always sparse")
+	fCompress  = flag.String("compress", `none`, "Tile compression: none, gzip, or zstd")
+	fChecksums = flag.Bool("checksums", false, "Store a CRC32C after each tile")
+)
+
+func main() {
+	var err error
+	var c tilemap.Config
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("Invalid command, need %s [options] <input tilemap or tileset dir> <output>\n", os.Args[0])
+	}
+	if c.Curve, err = tilemap.ParseCurve(*fCurve); err != nil {
+		log.Fatalf("bad curve %q: %v\n", *fCurve, err)
+	} else if c.Index, err = tilemap.ParseIndexType(*fIndex); err != nil {
+		log.Fatalf("bad index %q: %v\n", *fIndex, err)
+	} else if c.Compression, err = tilemap.ParseCompression(*fCompress); err != nil {
+		log.Fatalf("bad compression %q: %v\n", *fCompress, err)
+	}
+	c.Checksums = *fChecksums
+
+	src, dst := args[0], args[1]
+	fi, err := os.Stat(src)
+	if err != nil {
+		log.Fatalf("bad input %s: %v\n", src, err)
+	}
+	if fi.IsDir() {
+		err = convertTileset(src, dst, c)
+	} else {
+		err = convertTilemap(src, dst, c)
+	}
+	if err != nil {
+		log.Fatalf("Failed to convert %s: %v\n", src, err)
+	}
+}
+
+func convertTilemap(src, dst string, c tilemap.Config) (err error) {
+	var tm *tilemap.Tilemap
+	if tm, err = tilemap.NewTilemapConfig(src, tilemap.Config{Zoom: tilemap.AnyZoom, ReadOnly: true}); err != nil {
+		return
+	}
+	if err = tilemap.Rewrite(tm, dst, c); err != nil {
+		tm.Close()
+		return
+	}
+	err = tm.Close()
+	return
+}
+
+func convertTileset(src, dst string, c tilemap.Config) (err error) {
+	var ts *tilemap.Tileset
+	if ts, err = tilemap.OpenTileset(src, true); err != nil {
+		return
+	}
+	defer ts.Close()
+	if err = os.MkdirAll(dst, 0750); err != nil {
+		return
+	}
+	for _, z := range ts.Zooms() {
+		pth := filepath.Join(dst, fmt.Sprintf("%d%s", z, tilemap.TilesExtension))
+		if err = tilemap.Rewrite(ts.Tilemap(z), pth, c); err != nil {
+			return
+		}
+		log.Printf("Converted zoom %d\n", z)
+	}
+	return
+}
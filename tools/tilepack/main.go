@@ -0,0 +1,17 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/gravwell/tilemap"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatalf("Invalid command, need %s <tiles dir> <output container>\n", os.Args[0])
+	}
+	if err := tilemap.PackTileset(os.Args[1], os.Args[2]); err != nil {
+		log.Fatalf("Failed to pack %s: %v\n", os.Args[1], err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/gravwell/tilemap"
+)
+
+var (
+	fOut = flag.String("out", ``, "Apply the patch to a new generation at this path instead of in place")
+)
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+	var err error
+	switch args[0] {
+	case `diff`:
+		if len(args) != 4 {
+			usage()
+		}
+		err = diff(args[1], args[2], args[3])
+	case `apply`:
+		if len(args) != 3 {
+			usage()
+		}
+		err = apply(args[1], args[2], *fOut)
+	case `info`:
+		if len(args) != 2 {
+			usage()
+		}
+		var ps tilemap.PatchStats
+		if ps, err = tilemap.ReadPatchHeader(args[1]); err == nil {
+			logPatch(args[1], ps)
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalf("Failed to %s: %v\n", args[0], err)
+	}
+}
+
+func usage() {
+	log.Fatalf("Invalid command, need one of\n"+
+		"\t%[1]s diff <old tilemap> <new tilemap> <patch>\n"+
+		"\t%[1]s [-out <new tilemap>] apply <tilemap> <patch>\n"+
+		"\t%[1]s info <patch>\n", os.Args[0])
+}
+
+func diff(oldPth, newPth, patch string) (err error) {
+	var old, nw *tilemap.Tilemap
+	if old, err = tilemap.NewTilemapConfig(oldPth, tilemap.Config{Zoom: tilemap.AnyZoom, ReadOnly: true}); err != nil {
+		return
+	}
+	defer old.Close()
+	if nw, err = tilemap.NewTilemapConfig(newPth, tilemap.Config{Zoom: tilemap.AnyZoom, ReadOnly: true}); err != nil {
+		return
+	}
+	defer nw.Close()
+	var ps tilemap.PatchStats
+	if ps, err = tilemap.Diff(old, nw, patch); err == nil {
+		logPatch(patch, ps)
+	}
+	return
+}
+
+func apply(pth, patch, out string) (err error) {
+	var tm *tilemap.Tilemap
+	if tm, err = tilemap.NewTilemapConfig(pth, tilemap.Config{Zoom: tilemap.AnyZoom, ReadOnly: out != ``}); err != nil {
+		return
+	}
+	if out != `` {
+		err = tilemap.ApplyPatchTo(tm, patch, out)
+	} else {
+		err = tm.ApplyPatch(patch)
+	}
+	if lerr := tm.Close(); err == nil {
+		err = lerr
+	}
+	if err == nil {
+		if out == `` {
+			out = pth
+		}
+		log.Printf("Patched %s\n", out)
+	}
+	return
+}
+
+func logPatch(pth string, ps tilemap.PatchStats) {
+	log.Printf("%s: zoom %d, %d tiles changed, %d deleted, %d blobs, %d bytes\n",
+		pth, ps.Zoom, ps.Puts, ps.Deletes, ps.Blobs, ps.Bytes)
+	log.Printf("base %v\n", ps.Base)
+	log.Printf("result %v\n", ps.Result)
+}
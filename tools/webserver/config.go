@@ -8,17 +8,21 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"time"
+
+	"github.com/gravwell/tilemap"
 )
 
 const (
-	TilesExtension string = `.tiles`
-
 	envBindAddr      string = `BIND_ADDRESS`
 	envBindPort      string = `BIND_PORT`
 	envFileDir       string = `FILE_DIR`
 	envTilesDir      string = `TILES_DIR`
 	envLogFile       string = `LOG_FILE`
 	envAccessLogFile string = `ACCESS_LOG_FILE`
+	envRefresh       string = `REFRESH_INTERVAL`
+	envOverzoom      string = `OVERZOOM_LEVELS`
+	envOverzoomCache string = `OVERZOOM_CACHE_MB`
 )
 
 type Config struct {
@@ -28,6 +32,11 @@ type Config struct {
 	TilesDir      string `json:"tiles-dir"`
 	AccessLogFile string `json:"access-log-file"`
 	LogFile       string `json:"log-file"`
+	Refresh       string `json:"refresh-interval"`  //poll for tiles from a concurrent writer, e.g. 10s
+	Overzoom      uint16 `json:"overzoom-levels"`   //synthesize tiles up to this many zooms past the highest stored zoom
+	OverzoomCache uint16 `json:"overzoom-cache-mb"` //memory for synthesized tiles, defaults to 64MB
+
+	refreshInterval time.Duration
 }
 
 func LoadConfig(pth string) (c Config, err error) {
@@ -50,7 +59,10 @@ func (c *Config) validate() (err error) {
 	loadEnvString(&c.TilesDir, envTilesDir)
 	loadEnvString(&c.LogFile, envLogFile)
 	loadEnvString(&c.AccessLogFile, envAccessLogFile)
+	loadEnvString(&c.Refresh, envRefresh)
 	loadEnvUint16(&c.BindPort, envBindPort)
+	loadEnvUint16(&c.Overzoom, envOverzoom)
+	loadEnvUint16(&c.OverzoomCache, envOverzoomCache)
 
 	//check some sanity
 	if c.BindAddr == `` {
@@ -63,15 +75,30 @@ func (c *Config) validate() (err error) {
 		err = fmt.Errorf("invalid bind port, must be between 0 and 65535")
 		return
 	}
+	if c.Overzoom > tilemap.MaxZoom {
+		err = fmt.Errorf("invalid overzoom levels, must be <= %d", tilemap.MaxZoom)
+		return
+	}
 
+	//tiles can be a directory of tilemaps or a packed tileset container
 	var fi os.FileInfo
 	if fi, err = os.Stat(c.TilesDir); err != nil {
 		return
-	} else if fi.Mode().IsDir() == false {
-		err = fmt.Errorf("map file path %s is not a directory", c.TilesDir)
+	} else if fi.Mode().IsDir() == false && fi.Mode().IsRegular() == false {
+		err = fmt.Errorf("map file path %s is not a directory or tileset container", c.TilesDir)
 		return
 	}
 
+	if c.Refresh != `` {
+		if c.refreshInterval, err = time.ParseDuration(c.Refresh); err != nil {
+			err = fmt.Errorf("invalid refresh interval %q: %v", c.Refresh, err)
+			return
+		} else if c.refreshInterval < 0 {
+			err = fmt.Errorf("invalid refresh interval %q", c.Refresh)
+			return
+		}
+	}
+
 	//check if we have a file directory specified
 	if c.FileDir != `` {
 		if fi, err = os.Stat(c.FileDir); err != nil {
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,7 +18,9 @@ import (
 )
 
 const (
-	maxZoom = 20
+	maxZoom = tilemap.MaxZoom
+
+	extPattern = `{ext:png|jpg|jpeg|webp|pbf|mvt|json}`
 )
 
 var (
@@ -29,13 +32,15 @@ type Webserver struct {
 	http.Server
 	sync.WaitGroup
 	lst  net.Listener
-	tm   []*tilemap.Tilemap
+	ts   *tilemap.Tileset
+	oz   *tilemap.Overzoomer //nil unless overzoom is enabled
 	accW io.WriteCloser
 	lgrW io.WriteCloser
 	lgr  *log.Logger
+	done chan struct{}
 }
 
-func NewWebserver(c Config, maps []*tilemap.Tilemap) (w *Webserver, err error) {
+func NewWebserver(c Config, ts *tilemap.Tileset) (w *Webserver, err error) {
 	var lst net.Listener
 	if lst, err = net.Listen("tcp", c.BindString()); err != nil {
 		return
@@ -44,7 +49,8 @@ func NewWebserver(c Config, maps []*tilemap.Tilemap) (w *Webserver, err error) {
 	w = &Webserver{
 		Config: c,
 		lst:    lst,
-		tm:     maps,
+		ts:     ts,
+		done:   make(chan struct{}),
 		Server: http.Server{
 			WriteTimeout: 5 * time.Second, //these are tiny files, so this is even kind of nuts
 			ReadTimeout:  time.Second,
@@ -57,8 +63,18 @@ func NewWebserver(c Config, maps []*tilemap.Tilemap) (w *Webserver, err error) {
 		return
 	}
 	w.lgr = log.New(w.lgrW, ``, log.LUTC|log.Lshortfile|log.LstdFlags)
+	if c.Overzoom > 0 {
+		w.oz = tilemap.NewOverzoomer(ts, tilemap.OverzoomConfig{
+			MaxLevels: int(c.Overzoom),
+			CacheSize: int64(c.OverzoomCache) * 1024 * 1024,
+		})
+	}
 	rtr := mux.NewRouter()
-	rtr.HandleFunc(`/tiles/{zoom:\d+}/{x:\d+}/{y:\d+}.png`, w.tileHandler).Methods(`GET`)
+	rtr.HandleFunc(`/tiles/{zoom:\d+}/{x:\d+}/{y:\d+}.`+extPattern, w.tileHandler).Methods(`GET`)
+	rtr.HandleFunc(`/tiles/tms/{zoom:\d+}/{x:\d+}/{y:\d+}.`+extPattern, w.tmsHandler).Methods(`GET`)
+	//the zoom 0 tile has an empty quadkey and is served at /tiles/q/ or /tiles/q/.png
+	rtr.HandleFunc(`/tiles/q/{quadkey:[0-3]*}`, w.quadkeyHandler).Methods(`GET`)
+	rtr.HandleFunc(`/tiles/q/{quadkey:[0-3]*}.`+extPattern, w.quadkeyHandler).Methods(`GET`)
 	if c.FileDir != `` {
 		rtr.NotFoundHandler = fhandler{http.FileServer(http.Dir(filepath.Clean(c.FileDir)))}
 	}
@@ -72,15 +88,106 @@ func (ws *Webserver) tileHandler(w http.ResponseWriter, r *http.Request) {
 	zoom, x, y, err := getTileVars(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-	} else if zoom < 0 || zoom >= len(ws.tm) || ws.tm[zoom] == nil {
-		w.WriteHeader(http.StatusNotFound)
-	} else if tbuff, err := ws.tm[zoom].GetTile(x, y); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		ws.lgr.Printf("ERROR GetTile %d/%d/%d - %v\n", zoom, x, y, err)
+		return
+	}
+	ws.serveTile(w, r, zoom, x, y)
+}
+
+// tmsHandler serves tiles addressed with TMS coordinates, the y axis is flipped
+func (ws *Webserver) tmsHandler(w http.ResponseWriter, r *http.Request) {
+	zoom, x, y, err := getTileVars(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c := tilemap.TMSCoord{Zoom: zoom, X: x, Y: y}.XYZ()
+	ws.serveTile(w, r, c.Zoom, c.X, c.Y)
+}
+
+// quadkeyHandler serves tiles addressed with Bing style quadkeys, an empty key is zoom 0
+func (ws *Webserver) quadkeyHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := tilemap.ParseQuadkey(mux.Vars(r)[`quadkey`])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ws.serveTile(w, r, c.Zoom, c.X, c.Y)
+}
+
+// serveTile writes a tile with the MIME type of its zoom level.  Requests with an extension
+// have to match the tile format, a tilemap of png tiles will not answer for .jpg.
+// When overzoom is enabled tiles past the highest stored zoom are synthesized and take the
+// format of that zoom.
+func (ws *Webserver) serveTile(w http.ResponseWriter, r *http.Request, zoom, x, y int) {
+	var err error
+	var n int64
+	src := zoom
+	if ws.oz != nil {
+		src = ws.oz.Source(zoom)
+	}
+	if ext, ok := mux.Vars(r)[`ext`]; ok {
+		if f, err := tilemap.ParseFormat(ext); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		} else if tf := ws.ts.Format(src); tf != tilemap.FormatUnknown && tf != f {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	if mime := ws.ts.MimeType(src); mime != `` {
+		w.Header().Set("Content-Type", mime)
+	}
+	if src != zoom {
+		var buff []byte
+		if buff, err = ws.oz.GetTile(zoom, x, y); err == nil {
+			var wn int
+			wn, err = w.Write(buff)
+			n = int64(wn)
+		}
+	} else if enc := ws.ts.Compression(zoom).Encoding(); enc != `` {
+		//compressed tilemaps can hand their blobs straight to clients that accept the encoding
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsEncoding(r, enc) {
+			w.Header().Set("Content-Encoding", enc)
+			n, err = ws.ts.WriteRawTileTo(zoom, x, y, w)
+		} else {
+			n, err = ws.ts.WriteTileTo(zoom, x, y, w)
+		}
 	} else {
-		w.Header().Set("Content-Type", "image/png")
-		w.Write(tbuff)
+		n, err = ws.ts.WriteTileTo(zoom, x, y, w)
+	}
+	if err != nil && n == 0 {
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Encoding")
+		switch err {
+		case tilemap.ErrTileNotFound, tilemap.ErrOverzoomUnsupported:
+			w.WriteHeader(http.StatusNotFound)
+		case tilemap.ErrInvalidTileID:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			ws.lgr.Printf("ERROR GetTile %d/%d/%d - %v\n", zoom, x, y, err)
+		}
+	}
+}
+
+// acceptsEncoding checks the Accept-Encoding header for an encoding that is not refused with q=0
+func acceptsEncoding(r *http.Request, enc string) bool {
+	for _, hv := range r.Header.Values("Accept-Encoding") {
+		for _, v := range strings.Split(hv, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(v), ";")
+			if strings.TrimSpace(name) != enc {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					return false
+				}
+			}
+			return true
+		}
 	}
+	return false
 }
 
 func getTileVars(r *http.Request) (zoom, x, y int, err error) {
@@ -124,11 +231,34 @@ func (w *Webserver) Start() (err error) {
 	} else {
 		w.Add(1)
 		go w.run()
+		if w.refreshInterval > 0 {
+			w.Add(1)
+			go w.refresher()
+		}
 	}
 
 	return
 }
 
+// refresher periodically picks up tiles and zoom levels written by a concurrent renderer
+func (w *Webserver) refresher() {
+	defer w.Done()
+	tckr := time.NewTicker(w.refreshInterval)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-tckr.C:
+			if err := w.ts.Refresh(); err != nil {
+				w.lgr.Printf("ERROR Failed to refresh tiles: %v\n", err)
+			} else if w.oz != nil {
+				w.oz.Purge() //synthesized tiles may be stale
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
 func (w *Webserver) run() {
 	defer w.Done()
 	w.Serve(w.lst)
@@ -138,6 +268,7 @@ func (w *Webserver) Close() (err error) {
 	if err = w.lst.Close(); err != nil {
 		w.lgr.Printf("ERROR Failed to close listener: %v\n", err)
 	}
+	close(w.done)
 	w.Wait()
 	w.accW.Close()
 	w.lgrW.Close()
@@ -42,7 +42,7 @@ func rawFdCall(fio *os.File, cf controlFunc) error {
 func safeFallocate(fio *os.File, oldsz, newsz int64) (err error) {
 	//attempt to use syscall.Fallocate
 	err = rawFdCall(fio, func(fd uintptr) (lerr error) {
-		if errno := syscall.Fallocate(int(fd), 0, oldsz, newsz); errno != nil {
+		if errno := syscall.Fallocate(int(fd), 0, oldsz, newsz-oldsz); errno != nil {
 			if errno == syscall.ENOTSUP || errno == syscall.EOPNOTSUPP {
 				lerr = slowFileAllocate(fio, oldsz, newsz)
 			} else {
@@ -95,3 +95,17 @@ func getAttr(f *os.File) (attr uint32, err error) {
 	})
 	return
 }
+
+// syncDir flushes directory metadata so that renames are durable
+func syncDir(pth string) (err error) {
+	var dir *os.File
+	if dir, err = os.Open(pth); err != nil {
+		return
+	}
+	if err = dir.Sync(); err != nil {
+		dir.Close()
+	} else {
+		err = dir.Close()
+	}
+	return
+}
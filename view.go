@@ -0,0 +1,117 @@
+package tilemap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	dataMapGrowth = 64 * 1024 * 1024 //map with headroom so appends rarely remap
+)
+
+var (
+	ErrNotMapped = errors.New("tilemap was not opened with MapData")
+)
+
+// mapData maps the tilemap read only through at least the current end of data.
+// Any previous mapping is retained until Close so outstanding views remain valid.
+func (w *Tilemap) mapData() (err error) {
+	var r region
+	if r, err = w.st.mapData(w.foff); err != nil {
+		return
+	}
+	if w.dreg != nil {
+		w.oldMaps = append(w.oldMaps, w.dreg)
+	}
+	w.dreg = r
+	w.dmm = r.bytes()
+	return
+}
+
+func (w *Tilemap) unmapData() {
+	w.vmtx.Lock()
+	defer w.vmtx.Unlock()
+	for _, r := range w.oldMaps {
+		r.unmap()
+	}
+	w.oldMaps = nil
+	if w.dreg != nil {
+		w.dreg.unmap()
+	}
+	w.dreg = nil
+	w.dmm = nil
+}
+
+// GetTileView returns the tile at x, y out of the file mapping without copying it, ErrNotMapped without MapData.
+// A view is valid until the tilemap is closed and must never be modified.
+func (w *Tilemap) GetTileView(x, y int) (view []byte, err error) {
+	if !w.mapped {
+		err = ErrNotMapped
+		return
+	}
+	w.RLock()
+	defer w.RUnlock()
+	if view, err = w.rawView(x, y); err == nil {
+		view, err = w.decode(view)
+	}
+	return
+}
+
+// rawView returns the stored form of a tile out of the mapping, caller must hold the read lock
+func (w *Tilemap) rawView(x, y int) (view []byte, err error) {
+	var dp datapointer
+	if dp, err = w.lookup(x, y); err != nil {
+		return
+	} else if !w.validDataPointer(dp) {
+		err = errorLine(fmt.Errorf("%v %x:%x %x:%x",
+			ErrInvalidDatapointer, dp.offset, dp.offset+dp.size, w.dataStart, w.foff))
+		return
+	}
+	end := dp.offset + dp.size
+	if w.buffered(dp.offset) {
+		//not written out yet, hand back a copy since the buffer is reused
+		view = make([]byte, dp.size)
+		copy(view, w.wbuf[dp.offset-w.wbase:])
+	} else {
+		view = w.dmm[dp.offset:end:end]
+	}
+	if err = w.verifyRead(dp, view); err != nil {
+		view = nil
+	}
+	return
+}
+
+// WriteTileTo writes the tile at x, y to wtr.  Mapped tilemaps stream straight out of the mapping,
+// which stays pinned until the write completes so a concurrent Close cannot pull it away.
+func (w *Tilemap) WriteTileTo(x, y int, wtr io.Writer) (n int64, err error) {
+	return w.writeTo(x, y, wtr, false)
+}
+
+func (w *Tilemap) writeTo(x, y int, wtr io.Writer, raw bool) (n int64, err error) {
+	var buff []byte
+	var wn int
+	if w.mapped {
+		w.vmtx.RLock()
+		defer w.vmtx.RUnlock()
+		if w.dmm == nil {
+			err = errors.New("tilemap is closed")
+			return
+		}
+	}
+	w.RLock()
+	if w.mapped {
+		buff, err = w.rawView(x, y)
+	} else {
+		buff, err = w.getRaw(x, y)
+	}
+	if err == nil && !raw {
+		buff, err = w.decode(buff)
+	}
+	w.RUnlock()
+	if err == nil {
+		wn, err = wtr.Write(buff)
+		n = int64(wn)
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package tilemap
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestTilemapViews(t *testing.T) {
+	zl := 2
+	pth := filepath.Join(tdir, `views`)
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, MapData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wtr.Add(0, 0, []byte(`first`)); err != nil {
+		t.Fatal(err)
+	}
+	view, err := wtr.GetTileView(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(view) != `first` {
+		t.Fatalf("bad view %q", view)
+	}
+	if _, err = wtr.GetTileView(1, 1); err != ErrTileNotFound {
+		t.Fatalf("Failed to get ErrTileNotFound: %v", err)
+	}
+
+	//views survive replacement and compaction until the tilemap is closed
+	if err = wtr.Add(0, 0, []byte(`second`)); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if string(view) != `first` {
+		t.Fatalf("view changed after compaction: %q", view)
+	}
+	bb := bytes.NewBuffer(nil)
+	if n, err := wtr.WriteTileTo(0, 0, bb); err != nil {
+		t.Fatal(err)
+	} else if n != int64(len(`second`)) || bb.String() != `second` {
+		t.Fatalf("bad WriteTileTo %d %q", n, bb.String())
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//unmapped tilemaps still stream but do not hand out views
+	rdr, err := NewTilemap(pth, zl, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.GetTileView(0, 0); err != ErrNotMapped {
+		t.Fatalf("Failed to get ErrNotMapped: %v", err)
+	}
+	bb.Reset()
+	if _, err = rdr.WriteTileTo(0, 0, bb); err != nil {
+		t.Fatal(err)
+	} else if bb.String() != `second` {
+		t.Fatalf("bad WriteTileTo %q", bb.String())
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTilesetViews(t *testing.T) {
+	dir := filepath.Join(tdir, `tilesetviews`)
+	ts, err := OpenTileset(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err = ts.Add(1, i/2, i%2, []byte(fmt.Sprintf("tile %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cpth := filepath.Join(tdir, `tilesetviews.container`)
+	if err = PackTileset(dir, cpth); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err = OpenTilesetConfig(cpth, Config{ReadOnly: true, MapData: true}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if view, err := ts.GetTileView(1, i/2, i%2); err != nil {
+			t.Fatal(err)
+		} else if string(view) != fmt.Sprintf("tile %d", i) {
+			t.Fatalf("bad view %q", view)
+		}
+	}
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
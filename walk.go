@@ -0,0 +1,198 @@
+package tilemap
+
+import (
+	"errors"
+	"iter"
+	"sort"
+)
+
+const (
+	walkChunk = 64 * 1024 //tiles scanned per hold of the read lock
+)
+
+var (
+	errStopWalk = errors.New("stop walk")
+)
+
+// WalkOrder controls the order tiles are handed out by a walk
+type WalkOrder int
+
+const (
+	OrderTileID WalkOrder = iota //ascending tile id, which follows the file's Curve
+	OrderOffset                  //ascending blob offset, reading tiles in this order is sequential IO
+)
+
+// TileBounds limits a walk to tiles with MinX <= x <= MaxX and MinY <= y <= MaxY
+type TileBounds struct {
+	MinX, MinY int
+	MaxX, MaxY int
+}
+
+func (b TileBounds) contains(x, y int) bool {
+	return x >= b.MinX && x <= b.MaxX && y >= b.MinY && y <= b.MaxY
+}
+
+// WalkOptions filters and orders a walk, the zero value walks every tile in tile id order
+type WalkOptions struct {
+	Bounds   *TileBounds //nil walks the entire map
+	Distinct bool        //only hand out the first tile that references each blob
+	Order    WalkOrder
+}
+
+// TileInfo describes a populated tile
+type TileInfo struct {
+	X, Y int
+	Size int64
+}
+
+type walkEntry struct {
+	TileInfo
+	tid    uint64
+	offset int64
+}
+
+// Walk calls fn for every populated tile in tile id order, only the index is scanned.
+// A non-nil error from fn stops the walk and is returned.
+func (w *Tilemap) Walk(fn func(x, y int, size int64) error) error {
+	return w.WalkWith(WalkOptions{}, fn)
+}
+
+// WalkWith calls fn for every populated tile selected by opts, fn may call back into the tilemap
+func (w *Tilemap) WalkWith(opts WalkOptions, fn func(x, y int, size int64) error) error {
+	return w.walk(opts, func(t TileInfo) error {
+		return fn(t.X, t.Y, t.Size)
+	})
+}
+
+// Tiles returns a range iterator over the populated tiles selected by opts, see WalkWith
+func (w *Tilemap) Tiles(opts WalkOptions) iter.Seq[TileInfo] {
+	return func(yield func(TileInfo) bool) {
+		w.walk(opts, func(t TileInfo) error {
+			if !yield(t) {
+				return errStopWalk
+			}
+			return nil
+		})
+	}
+}
+
+func (w *Tilemap) walk(opts WalkOptions, fn func(TileInfo) error) (err error) {
+	w.RLock()
+	mb := w.hdr.Region.Bounds(w.zoom)
+	rows := w.hdr.Index == IndexDense && w.hdr.Curve == CurveRowMajor
+	w.RUnlock()
+	b, ok := walkBounds(mb, opts.Bounds)
+	if !ok {
+		return //nothing within the map
+	}
+	if rows && opts.Order == OrderTileID {
+		err = w.walkRows(b, opts.Distinct, fn)
+	} else {
+		err = w.walkSorted(b, opts, fn)
+	}
+	if err == errStopWalk {
+		err = nil
+	}
+	return
+}
+
+// walkBounds clips the requested bounds to the map bounds, ok is false if nothing is left
+func walkBounds(mb TileBounds, req *TileBounds) (b TileBounds, ok bool) {
+	b = mb
+	if req != nil {
+		b.MinX = max(b.MinX, req.MinX)
+		b.MinY = max(b.MinY, req.MinY)
+		b.MaxX = min(b.MaxX, req.MaxX)
+		b.MaxY = min(b.MaxY, req.MaxY)
+	}
+	ok = b.MinX <= b.MaxX && b.MinY <= b.MaxY
+	return
+}
+
+// walkRows scans a dense row major index a chunk of each row at a time
+func (w *Tilemap) walkRows(b TileBounds, distinct bool, fn func(TileInfo) error) (err error) {
+	var seen map[int64]es
+	if distinct {
+		seen = make(map[int64]es)
+	}
+	var dp datapointer
+	var batch []TileInfo
+	for x := b.MinX; x <= b.MaxX; x++ {
+		for y := b.MinY; y <= b.MaxY; y += walkChunk {
+			end := min(y+walkChunk-1, b.MaxY)
+			batch = batch[:0]
+			w.RLock()
+			for yy := y; yy <= end; yy++ {
+				if dp, err = w.getDataPointer(w.tileid(x, yy)); err != nil {
+					w.RUnlock()
+					return
+				} else if !w.published(dp) {
+					continue
+				}
+				if distinct {
+					if _, ok := seen[dp.offset]; ok {
+						continue
+					}
+					seen[dp.offset] = es{}
+				}
+				batch = append(batch, TileInfo{X: x, Y: yy, Size: dp.size})
+			}
+			w.RUnlock()
+			for _, t := range batch {
+				if err = fn(t); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// walkSorted snapshots the matching tiles and sorts them, used for sparse indexes, curves, and offset ordering
+func (w *Tilemap) walkSorted(b TileBounds, opts WalkOptions, fn func(TileInfo) error) (err error) {
+	var ents []walkEntry
+	w.RLock()
+	err = w.walkIndex(func(tid uint64, dp datapointer) error {
+		x, y := w.tilexy(tid)
+		if b.contains(x, y) && w.published(dp) {
+			ents = append(ents, walkEntry{
+				TileInfo: TileInfo{X: x, Y: y, Size: dp.size},
+				tid:      tid,
+				offset:   dp.offset,
+			})
+		}
+		return nil
+	})
+	w.RUnlock()
+	if err != nil {
+		return
+	}
+	if opts.Order == OrderOffset {
+		sort.Slice(ents, func(i, j int) bool {
+			if ents[i].offset == ents[j].offset {
+				return ents[i].tid < ents[j].tid
+			}
+			return ents[i].offset < ents[j].offset
+		})
+	} else {
+		sort.Slice(ents, func(i, j int) bool {
+			return ents[i].tid < ents[j].tid
+		})
+	}
+	var seen map[int64]es
+	if opts.Distinct {
+		seen = make(map[int64]es)
+	}
+	for _, e := range ents {
+		if opts.Distinct {
+			if _, ok := seen[e.offset]; ok {
+				continue
+			}
+			seen[e.offset] = es{}
+		}
+		if err = fn(e.TileInfo); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package tilemap
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestTilemapWalk(t *testing.T) {
+	for _, idx := range []IndexType{IndexDense, IndexSparse} {
+		t.Run(idx.String(), func(t *testing.T) {
+			testWalk(t, idx)
+		})
+	}
+}
+
+func testWalk(t *testing.T, idx IndexType) {
+	zl := 4
+	pth := filepath.Join(tdir, `walk`+idx.String())
+	wtr, err := NewTilemapConfig(pth, Config{Zoom: zl, Index: idx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//write in reverse so file order and tile order disagree, every third tile shares a blob
+	shared := []byte(`shared`)
+	var tids []uint64
+	for x := 15; x >= 0; x -= 3 {
+		for y := 15; y >= 0; y -= 5 {
+			buff := []byte(fmt.Sprintf("%d/%d", x, y))
+			if len(tids)%3 == 0 {
+				buff = shared
+			}
+			if err = wtr.Add(x, y, buff); err != nil {
+				t.Fatal(err)
+			}
+			tids = append(tids, tileid(zl, x, y))
+		}
+	}
+
+	var n int
+	var last uint64
+	err = wtr.Walk(func(x, y int, size int64) error {
+		tid := tileid(zl, x, y)
+		if n > 0 && tid <= last {
+			return fmt.Errorf("out of order %d/%d", x, y)
+		} else if sz, err := wtr.TileSize(x, y); err != nil || sz != size {
+			return fmt.Errorf("bad size %d/%d %d %v", x, y, size, err)
+		}
+		last = tid
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if n != len(tids) {
+		t.Fatalf("walked %d of %d tiles", n, len(tids))
+	}
+
+	//offset order hands blobs out in the order they were written, the shared blob came first
+	n = 0
+	for ti := range wtr.Tiles(WalkOptions{Order: OrderOffset, Distinct: true}) {
+		if n == 0 && ti.Size != int64(len(shared)) {
+			t.Fatalf("first tile in offset order is not the first written: %+v", ti)
+		} else if n == 1 && (ti.X != 15 || ti.Y != 10) {
+			t.Fatalf("second tile in offset order is not the second written: %+v", ti)
+		}
+		n++
+	}
+	if distinct := len(tids) - (len(tids)-1)/3; n != distinct {
+		t.Fatalf("distinct walk returned %d != %d", n, distinct)
+	}
+
+	//bounds are inclusive and clipped to the map
+	n = 0
+	for ti := range wtr.Tiles(WalkOptions{Bounds: &TileBounds{MinX: 10, MinY: -5, MaxX: 100, MaxY: 5}}) {
+		if ti.X < 10 || ti.Y > 5 {
+			t.Fatalf("tile outside of bounds %+v", ti)
+		}
+		n++
+	}
+	if n != 4 { //x of 12 and 15, y of 0 and 5
+		t.Fatalf("bounded walk returned %d tiles", n)
+	}
+	for range wtr.Tiles(WalkOptions{Bounds: &TileBounds{MinX: 20, MaxX: 30, MaxY: 15}}) {
+		t.Fatal("walk outside of the map returned a tile")
+	}
+
+	//early exit
+	n = 0
+	for range wtr.Tiles(WalkOptions{}) {
+		if n++; n == 2 {
+			break
+		}
+	}
+	stop := errors.New("stop")
+	if err = wtr.Walk(func(int, int, int64) error { return stop }); err != stop {
+		t.Fatalf("walk did not return the callback error: %v", err)
+	}
+	if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}